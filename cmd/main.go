@@ -3,14 +3,17 @@ package main
 import (
 	"Domain_IP_Selector_Go/internal/config"
 	"Domain_IP_Selector_Go/internal/engine"
+	"Domain_IP_Selector_Go/internal/origin"
 	"Domain_IP_Selector_Go/internal/output"
 	"Domain_IP_Selector_Go/internal/server"
+	"Domain_IP_Selector_Go/internal/usage"
 	_ "embed"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 //go:embed default_config.yaml
@@ -43,8 +46,15 @@ func ensureFile(fileName string, defaultData []byte) (string, error) {
 }
 
 func main() {
+	// server 子命令只运行测速源站，不需要配置文件
+	if len(os.Args) > 1 && os.Args[1] == "server" {
+		runOrigin(os.Args[2:])
+		return
+	}
+
 	// 定义命令行标志
 	cliMode := flag.Bool("cli", false, "以命令行模式运行")
+	importSources := flag.String("import", "", "从文件、URL 或标准输入（-）导入候选 IP 并跳过 DNS 解析，多个来源用逗号分隔（仅命令行模式）")
 	flag.Parse()
 
 	// 确保所有必需的文件都存在
@@ -65,15 +75,37 @@ func main() {
 
 	if *cliMode {
 		// --- 命令行模式 ---
-		runCli(cfgPath, locationsPath, domainsPath, exeDir)
+		runCli(cfgPath, locationsPath, domainsPath, exeDir, *importSources)
 	} else {
 		// --- Web 服务器模式 (默认) ---
 		server.Start(8080, cfgPath, locationsPath, domainsPath, exeDir)
 	}
 }
 
+// runOrigin 运行自建的测速源站，提供与 speed.cloudflare.com 兼容的下载、上传与 trace 接口
+func runOrigin(args []string) {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	addr := fs.String("listen", ":8081", "监听地址")
+	colo := fs.String("colo", "", "未经过 Cloudflare 直接访问时报告的数据中心代码，例如 HKG")
+	maxBytes := fs.Int64("max-bytes", origin.DefaultMaxBytes, "单次下载或上传允许的最大字节数")
+	certFile := fs.String("tls-cert", "", "TLS 证书文件，与 -tls-key 同时设置时使用 HTTPS")
+	keyFile := fs.String("tls-key", "", "TLS 私钥文件")
+	fs.Parse(args)
+
+	err := origin.Start(origin.Options{
+		Addr:     *addr,
+		Colo:     *colo,
+		MaxBytes: *maxBytes,
+		CertFile: *certFile,
+		KeyFile:  *keyFile,
+	})
+	if err != nil {
+		log.Fatalf("测速源站运行失败: %v", err)
+	}
+}
+
 // runCli 包含原始的命令行执行逻辑
-func runCli(cfgPath, locationsPath, domainsPath, exeDir string) {
+func runCli(cfgPath, locationsPath, domainsPath, exeDir, importSources string) {
 	log.Println("--- 以命令行模式运行 ---")
 
 	// 1. 加载配置
@@ -83,13 +115,19 @@ func runCli(cfgPath, locationsPath, domainsPath, exeDir string) {
 	}
 	log.Printf("配置加载成功：分组方式=%s, 每组优选IP数=%d", cfg.GroupBy, cfg.TopNPerGroup)
 
+	// 命令行指定的导入源优先于配置文件
+	if importSources != "" {
+		cfg.ImportSources = strings.Split(importSources, ",")
+	}
+
 	// 定义日志回调函数
 	progressCallback := func(message string) {
 		log.Println(message)
 	}
 
 	// 2. 运行优选引擎
-	finalResults, err := engine.Run(cfg, locationsPath, domainsPath, exeDir, progressCallback)
+	meter := usage.NewMeter()
+	finalResults, err := engine.Run(cfg, locationsPath, domainsPath, exeDir, meter, progressCallback)
 	if err != nil {
 		log.Fatalf("引擎运行时出错: %v", err)
 	}
@@ -102,6 +140,7 @@ func runCli(cfgPath, locationsPath, domainsPath, exeDir string) {
 	}
 	resultJSONFile := filepath.Join(exeDir, fmt.Sprintf("result_%s.json", ipVersion))
 	resultCSVFile := filepath.Join(exeDir, fmt.Sprintf("result_%s.csv", ipVersion))
+	resultMetaFile := filepath.Join(exeDir, fmt.Sprintf("result_%s_meta.json", ipVersion))
 
 	if err := output.WriteJSONFile(resultJSONFile, finalResults); err != nil {
 		log.Fatalf("写入 result.json 失败: %v", err)
@@ -109,7 +148,10 @@ func runCli(cfgPath, locationsPath, domainsPath, exeDir string) {
 	if err := output.WriteCSVFile(resultCSVFile, finalResults); err != nil {
 		log.Fatalf("写入 result.csv 失败: %v", err)
 	}
-	log.Printf("结果已成功写入 %s 和 %s", resultJSONFile, resultCSVFile)
+	if err := output.WriteMetadataFile(resultMetaFile, len(finalResults), meter.Report()); err != nil {
+		log.Fatalf("写入运行信息失败: %v", err)
+	}
+	log.Printf("结果已成功写入 %s、%s 和 %s", resultJSONFile, resultCSVFile, resultMetaFile)
 
 	log.Println("--- 所有任务已完成 ---")
 }
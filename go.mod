@@ -9,3 +9,19 @@ require gopkg.in/yaml.v3 v3.0.1
 require github.com/gorilla/websocket v1.5.3
 
 require golang.org/x/time v0.12.0
+
+require (
+	github.com/quic-go/quic-go v0.59.0
+	github.com/refraction-networking/utls v1.8.2
+	golang.org/x/net v0.43.0
+	golang.org/x/sys v0.35.0
+)
+
+require (
+	github.com/andybalholm/brotli v1.0.6 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+)
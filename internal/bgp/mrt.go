@@ -0,0 +1,227 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// MRT 格式常量，参见 RFC 6396 与 RFC 8050
+const (
+	mrtHeaderLen = 12
+	// maxMRTRecordLen 是单条 MRT 记录允许的最大长度，RIB 条目通常只有几 KB，
+	// 超过此长度的记录说明文件已损坏，避免按记录头中的长度分配过大的内存
+	maxMRTRecordLen = 16 << 20
+
+	mrtTypeTableDump   = 12
+	mrtTypeTableDumpV2 = 13
+
+	// TABLE_DUMP 子类型
+	tableDumpAFIIPv4 = 1
+	tableDumpAFIIPv6 = 2
+
+	// TABLE_DUMP_V2 子类型
+	ribIPv4Unicast        = 2
+	ribIPv6Unicast        = 4
+	ribIPv4UnicastAddPath = 8
+	ribIPv6UnicastAddPath = 10
+
+	bgpAttrASPath       = 2
+	bgpAttrFlagExtended = 0x10
+
+	asPathSegmentSet      = 1
+	asPathSegmentSequence = 2
+)
+
+var errTruncated = errors.New("MRT 记录被截断")
+
+// parseMRT 逐条读取 MRT 记录，将 RIB 条目中的前缀与源 ASN 写入路由表，其余记录类型会被跳过
+func parseMRT(r io.Reader, table *Table) error {
+	header := make([]byte, mrtHeaderLen)
+	var body []byte
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("读取 MRT 记录头失败: %w", err)
+		}
+		mrtType := binary.BigEndian.Uint16(header[4:6])
+		subtype := binary.BigEndian.Uint16(header[6:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		if length > maxMRTRecordLen {
+			return fmt.Errorf("MRT 记录长度 %d 超过上限 %d，文件可能已损坏", length, maxMRTRecordLen)
+		}
+
+		if cap(body) < int(length) {
+			body = make([]byte, length)
+		}
+		body = body[:length]
+		if _, err := io.ReadFull(r, body); err != nil {
+			return fmt.Errorf("读取 MRT 记录失败: %w", err)
+		}
+
+		var err error
+		switch mrtType {
+		case mrtTypeTableDumpV2:
+			switch subtype {
+			case ribIPv4Unicast:
+				err = parseRIBEntry(body, 32, false, table)
+			case ribIPv6Unicast:
+				err = parseRIBEntry(body, 128, false, table)
+			case ribIPv4UnicastAddPath:
+				err = parseRIBEntry(body, 32, true, table)
+			case ribIPv6UnicastAddPath:
+				err = parseRIBEntry(body, 128, true, table)
+			}
+		case mrtTypeTableDump:
+			switch subtype {
+			case tableDumpAFIIPv4:
+				err = parseTableDumpEntry(body, 4, table)
+			case tableDumpAFIIPv6:
+				err = parseTableDumpEntry(body, 16, table)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseRIBEntry 解析 TABLE_DUMP_V2 的 RIB_IPVx_UNICAST 记录，只使用第一条路径的 AS_PATH
+func parseRIBEntry(body []byte, bits int, addPath bool, table *Table) error {
+	// sequence number(4) + prefix length(1)
+	if len(body) < 5 {
+		return errTruncated
+	}
+	prefixLen := int(body[4])
+	if prefixLen > bits {
+		return fmt.Errorf("无效的前缀长度: %d", prefixLen)
+	}
+	prefixBytes := (prefixLen + 7) / 8
+	pos := 5
+	if len(body) < pos+prefixBytes+2 {
+		return errTruncated
+	}
+	ip := make(net.IP, bits/8)
+	copy(ip, body[pos:pos+prefixBytes])
+	pos += prefixBytes
+
+	entryCount := binary.BigEndian.Uint16(body[pos : pos+2])
+	pos += 2
+	if entryCount == 0 {
+		return nil
+	}
+
+	// peer index(2) + originated time(4) [+ path identifier(4)] + attribute length(2)
+	pos += 6
+	if addPath {
+		pos += 4
+	}
+	if len(body) < pos+2 {
+		return errTruncated
+	}
+	attrLen := int(binary.BigEndian.Uint16(body[pos : pos+2]))
+	pos += 2
+	if len(body) < pos+attrLen {
+		return errTruncated
+	}
+
+	asn, ok := originFromAttributes(body[pos:pos+attrLen], 4)
+	if !ok {
+		return nil
+	}
+	mask := net.CIDRMask(prefixLen, bits)
+	table.Add(&net.IPNet{IP: ip.Mask(mask), Mask: mask}, asn)
+	return nil
+}
+
+// parseTableDumpEntry 解析旧版 TABLE_DUMP 记录，其 AS_PATH 使用 2 字节 ASN
+func parseTableDumpEntry(body []byte, addrLen int, table *Table) error {
+	// view(2) + sequence(2) + prefix(addrLen) + prefix length(1) + status(1) + originated time(4)
+	// + peer IP(addrLen) + peer AS(2) + attribute length(2)
+	fixed := 2 + 2 + addrLen + 1 + 1 + 4 + addrLen + 2 + 2
+	if len(body) < fixed {
+		return errTruncated
+	}
+	ip := make(net.IP, addrLen)
+	copy(ip, body[4:4+addrLen])
+	prefixLen := int(body[4+addrLen])
+	if prefixLen > addrLen*8 {
+		return fmt.Errorf("无效的前缀长度: %d", prefixLen)
+	}
+	attrLen := int(binary.BigEndian.Uint16(body[fixed-2 : fixed]))
+	if len(body) < fixed+attrLen {
+		return errTruncated
+	}
+
+	asn, ok := originFromAttributes(body[fixed:fixed+attrLen], 2)
+	if !ok {
+		return nil
+	}
+	mask := net.CIDRMask(prefixLen, addrLen*8)
+	table.Add(&net.IPNet{IP: ip.Mask(mask), Mask: mask}, asn)
+	return nil
+}
+
+// originFromAttributes 从 BGP 路径属性中找到 AS_PATH 并返回源 ASN（路径中最后一个 AS）
+func originFromAttributes(attrs []byte, asnSize int) (uint32, bool) {
+	for pos := 0; pos+3 <= len(attrs); {
+		flags, attrType := attrs[pos], attrs[pos+1]
+		var length int
+		if flags&bgpAttrFlagExtended != 0 {
+			if pos+4 > len(attrs) {
+				return 0, false
+			}
+			length = int(binary.BigEndian.Uint16(attrs[pos+2 : pos+4]))
+			pos += 4
+		} else {
+			length = int(attrs[pos+2])
+			pos += 3
+		}
+		if pos+length > len(attrs) {
+			return 0, false
+		}
+		if attrType == bgpAttrASPath {
+			return originFromASPath(attrs[pos:pos+length], asnSize)
+		}
+		pos += length
+	}
+	return 0, false
+}
+
+// originFromASPath 返回 AS_PATH 中最后一个 AS_SEQUENCE 的最后一个 ASN；
+// 若路径以 AS_SET 结尾（聚合路由），则取该集合中的第一个 ASN
+func originFromASPath(path []byte, asnSize int) (uint32, bool) {
+	var (
+		origin uint32
+		found  bool
+	)
+	for pos := 0; pos+2 <= len(path); {
+		segType, count := path[pos], int(path[pos+1])
+		pos += 2
+		if pos+count*asnSize > len(path) {
+			return 0, false
+		}
+		if count > 0 {
+			idx := pos + (count-1)*asnSize
+			if segType == asPathSegmentSet {
+				idx = pos
+			}
+			if segType == asPathSegmentSet || segType == asPathSegmentSequence {
+				origin, found = readASN(path[idx:idx+asnSize]), true
+			}
+		}
+		pos += count * asnSize
+	}
+	return origin, found
+}
+
+func readASN(b []byte) uint32 {
+	if len(b) == 2 {
+		return uint32(binary.BigEndian.Uint16(b))
+	}
+	return binary.BigEndian.Uint32(b)
+}
@@ -0,0 +1,190 @@
+package bgp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Route 是路由表中的一条宣告
+type Route struct {
+	Prefix    *net.IPNet
+	OriginASN uint32
+}
+
+// Table 是离线路由表，支持最长前缀匹配
+type Table struct {
+	// routes 按前缀长度索引，键为网络地址的字符串形式
+	v4     [33]map[string]Route
+	v6     [129]map[string]Route
+	routes int
+}
+
+// NewTable 创建一个空的路由表
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Len 返回路由表中的前缀数量
+func (t *Table) Len() int {
+	return t.routes
+}
+
+// Add 向路由表中添加一条宣告，已存在的前缀保留首次添加的源 ASN
+func (t *Table) Add(prefix *net.IPNet, originASN uint32) {
+	ones, bits := prefix.Mask.Size()
+	var byLen []map[string]Route
+	if bits == 32 {
+		byLen = t.v4[:]
+	} else {
+		byLen = t.v6[:]
+	}
+	if byLen[ones] == nil {
+		byLen[ones] = make(map[string]Route)
+	}
+	key := prefix.IP.String()
+	if _, exists := byLen[ones][key]; exists {
+		return
+	}
+	byLen[ones][key] = Route{Prefix: prefix, OriginASN: originASN}
+	t.routes++
+}
+
+// Lookup 查找包含 ip 的最长前缀
+func (t *Table) Lookup(ip net.IP) (Route, bool) {
+	if t == nil {
+		return Route{}, false
+	}
+	var (
+		byLen []map[string]Route
+		bits  int
+	)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, byLen, bits = ip4, t.v4[:], 32
+	} else {
+		byLen, bits = t.v6[:], 128
+	}
+	for ones := bits; ones >= 0; ones-- {
+		if byLen[ones] == nil {
+			continue
+		}
+		key := ip.Mask(net.CIDRMask(ones, bits)).String()
+		if route, ok := byLen[ones][key]; ok {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// LoadTableFromFile 从磁盘加载路由表，自动识别以下格式（均可经 gzip 或 bzip2 压缩）：
+//   - MRT 格式的 RIB 转储（TABLE_DUMP / TABLE_DUMP_V2，如 RouteViews、RIPE RIS 的 rib/bview 文件）
+//   - 前缀到 ASN 的文本文件，每行 "1.0.0.0/24 13335"（pyasn）或 "1.0.0.0	24	13335"（CAIDA pfx2as）
+func LoadTableFromFile(filePath string) (*Table, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("无法打开路由表文件 '%s': %w", filePath, err)
+	}
+	defer file.Close()
+
+	reader, err := decompress(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("解压路由表文件 '%s' 失败: %w", filePath, err)
+	}
+
+	table := NewTable()
+	if isMRT(reader) {
+		err = parseMRT(reader, table)
+	} else {
+		err = parseText(reader, table)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析路由表文件 '%s' 失败: %w", filePath, err)
+	}
+	if table.Len() == 0 {
+		return nil, fmt.Errorf("路由表文件 '%s' 中未找到有效的前缀", filePath)
+	}
+	return table, nil
+}
+
+// decompress 根据文件头识别 gzip / bzip2 压缩
+func decompress(r *bufio.Reader) (*bufio.Reader, error) {
+	magic, _ := r.Peek(3)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return bufio.NewReader(gz), nil
+	case bytes.Equal(magic, []byte("BZh")):
+		return bufio.NewReader(bzip2.NewReader(r)), nil
+	default:
+		return r, nil
+	}
+}
+
+// isMRT 通过第一个 MRT 记录头中的类型字段判断是否为 MRT 文件
+func isMRT(r *bufio.Reader) bool {
+	header, err := r.Peek(mrtHeaderLen)
+	if err != nil {
+		return false
+	}
+	mrtType := binary.BigEndian.Uint16(header[4:6])
+	return mrtType == mrtTypeTableDump || mrtType == mrtTypeTableDumpV2
+}
+
+// parseText 解析前缀到 ASN 的文本文件，忽略空行、注释和无法解析的行
+func parseText(r io.Reader, table *Table) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+
+		var prefixStr, asnStr string
+		switch {
+		case len(fields) >= 2 && strings.Contains(fields[0], "/"):
+			prefixStr, asnStr = fields[0], fields[1]
+		case len(fields) >= 3:
+			prefixStr, asnStr = fields[0]+"/"+fields[1], fields[2]
+		default:
+			continue
+		}
+
+		_, prefix, err := net.ParseCIDR(prefixStr)
+		if err != nil {
+			continue
+		}
+		asn, ok := parseASN(asnStr)
+		if !ok {
+			continue
+		}
+		table.Add(prefix, asn)
+	}
+	return scanner.Err()
+}
+
+// parseASN 解析 ASN 字段，支持 "AS13335" 写法，多源（如 "13335_209242"）时取第一个
+func parseASN(s string) (uint32, bool) {
+	s = strings.TrimPrefix(strings.ToUpper(s), "AS")
+	if idx := strings.IndexAny(s, "_{"); idx >= 0 {
+		s = s[:idx]
+	}
+	asn, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(asn), true
+}
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,13 +16,113 @@ type Config struct {
 	LatencyTestConcurrency int      `yaml:"latency_test_concurrency" json:"latency_test_concurrency"`
 	SpeedTestConcurrency   int      `yaml:"speedtest_concurrency" json:"speedtest_concurrency"`
 	MaxLatency             int      `yaml:"max_latency" json:"max_latency"`
+	MaxJitter              int      `yaml:"max_jitter" json:"max_jitter"`
+	LatencyMode            string   `yaml:"latency_mode" json:"latency_mode"`
+	LatencyMinSamples      int      `yaml:"latency_min_samples" json:"latency_min_samples"`
+	LatencyMaxSamples      int      `yaml:"latency_max_samples" json:"latency_max_samples"`
+	LatencyCIPrecision     float64  `yaml:"latency_ci_precision" json:"latency_ci_precision"`
+	ScreenMode             string   `yaml:"screen_mode" json:"screen_mode"`
+	ScreenTimeoutMS        int      `yaml:"screen_timeout_ms" json:"screen_timeout_ms"`
+	ScreenKeepFraction     float64  `yaml:"screen_keep_fraction" json:"screen_keep_fraction"`
+	QUICEnabled            bool     `yaml:"quic_enabled" json:"quic_enabled"`
+	QUICPorts              []int    `yaml:"quic_ports" json:"quic_ports"`
+	RankBy                 string   `yaml:"rank_by" json:"rank_by"`
 	TopNPerGroup           int      `yaml:"top_n_per_group" json:"top_n_per_group"`
 	IPVersion              string   `yaml:"ip_version" json:"ip_version"`
+	Ports                  []int    `yaml:"ports" json:"ports"`
+	PlainHTTP              bool     `yaml:"plain_http" json:"plain_http"`
+	TLSFingerprint         string   `yaml:"tls_fingerprint" json:"tls_fingerprint"`
+	HTTPLatencyURL         string   `yaml:"http_latency_url" json:"http_latency_url"`
+	HTTPSpeedURL           string   `yaml:"http_speed_url" json:"http_speed_url"`
+	HTTPUploadURL          string   `yaml:"http_upload_url" json:"http_upload_url"`
+	SpeedURLs              []string `yaml:"speed_urls" json:"speed_urls"`
+	SpeedURLHealthCheck    bool     `yaml:"speed_url_health_check" json:"speed_url_health_check"`
+	SpeedURLMaxFailures    int      `yaml:"speed_url_max_failures" json:"speed_url_max_failures"`
 	SpeedTestRateLimitMB   float64  `yaml:"speedtest_rate_limit_mb" json:"speedtest_rate_limit_mb"`
+	SpeedTestStreams       int      `yaml:"speedtest_streams" json:"speedtest_streams"`
+	SpeedTestMinDuration   int      `yaml:"speedtest_min_duration_ms" json:"speedtest_min_duration_ms"`
+	SpeedTestMaxDuration   int      `yaml:"speedtest_max_duration_ms" json:"speedtest_max_duration_ms"`
+	SpeedTestTolerance     float64  `yaml:"speedtest_tolerance" json:"speedtest_tolerance"`
+	SpeedTestContention    float64  `yaml:"speedtest_contention_ratio" json:"speedtest_contention_ratio"`
 	GroupBy                string   `yaml:"group_by" json:"group_by"`
 	FilterRegions          []string `yaml:"filter_regions" json:"filter_regions"`
 	FilterColos            []string `yaml:"filter_colos" json:"filter_colos"`
+	FilterPrefixes         []string `yaml:"filter_prefixes" json:"filter_prefixes"`
+	ExtraCIDRs             []string `yaml:"extra_cidrs" json:"extra_cidrs"`
+	ExcludeCIDRs           []string `yaml:"exclude_cidrs" json:"exclude_cidrs"`
 	MinSpeed               float64  `yaml:"min_speed" json:"min_speed"`
+	UploadEnabled          bool     `yaml:"upload_enabled" json:"upload_enabled"`
+	UploadURL              string   `yaml:"upload_url" json:"upload_url"`
+	MinUploadSpeed         float64  `yaml:"min_upload_speed" json:"min_upload_speed"`
+	LoadedLatencyEnabled   bool     `yaml:"loaded_latency_enabled" json:"loaded_latency_enabled"`
+	LoadedLatencyInterval  int      `yaml:"loaded_latency_interval_ms" json:"loaded_latency_interval_ms"`
+	MaxBufferbloatGrade    string   `yaml:"max_bufferbloat_grade" json:"max_bufferbloat_grade"`
+	ShapingThrottleRatio   float64  `yaml:"shaping_throttle_ratio" json:"shaping_throttle_ratio"`
+	ImportSources          []string `yaml:"import_sources" json:"import_sources"`
+	BGPTableFile           string   `yaml:"bgp_table_file" json:"bgp_table_file"`
+
+	// 优质 IP 池
+	PoolEnabled      bool    `yaml:"pool_enabled" json:"pool_enabled"`
+	PoolMaxAgeDays   int     `yaml:"pool_max_age_days" json:"pool_max_age_days"`
+	PoolMaxFailures  int     `yaml:"pool_max_failures" json:"pool_max_failures"`
+	PoolHalfLifeDays float64 `yaml:"pool_half_life_days" json:"pool_half_life_days"`
+
+	// 隔离列表
+	QuarantineEnabled         bool    `yaml:"quarantine_enabled" json:"quarantine_enabled"`
+	QuarantineTimeoutStrikes  int     `yaml:"quarantine_timeout_strikes" json:"quarantine_timeout_strikes"`
+	QuarantineStatusStrikes   int     `yaml:"quarantine_status_strikes" json:"quarantine_status_strikes"`
+	QuarantineLowSpeedStrikes int     `yaml:"quarantine_low_speed_strikes" json:"quarantine_low_speed_strikes"`
+	QuarantineLowSpeedMB      float64 `yaml:"quarantine_low_speed_mb" json:"quarantine_low_speed_mb"`
+	QuarantineExpireHours     int     `yaml:"quarantine_expire_hours" json:"quarantine_expire_hours"`
+
+	// 延迟测试参数
+	LatencyProfile LatencyProfile `yaml:"latency_profile" json:"latency_profile"`
+}
+
+// LatencyProfile 是延迟测试请求的参数，零值字段使用内置默认值
+type LatencyProfile struct {
+	URL                 string   `yaml:"url" json:"url"`
+	ClientTimeoutMS     int      `yaml:"client_timeout_ms" json:"client_timeout_ms"`
+	DialTimeoutMS       int      `yaml:"dial_timeout_ms" json:"dial_timeout_ms"`
+	Method              string   `yaml:"method" json:"method"`
+	UserAgent           string   `yaml:"user_agent" json:"user_agent"`
+	AcceptedStatusCodes []int    `yaml:"accepted_status_codes" json:"accepted_status_codes"`
+	MaxLossRate         *float64 `yaml:"max_loss_rate" json:"max_loss_rate"` // 未设置时为 0.1，0 表示不允许任何丢包
+	PingCount           int      `yaml:"ping_count" json:"ping_count"`
+	PrescreenCount      int      `yaml:"prescreen_count" json:"prescreen_count"`
+}
+
+// Validate 检查延迟测试参数是否合理
+func (p LatencyProfile) Validate() error {
+	if p.URL != "" {
+		u, err := url.Parse(p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url 必须是完整的 http:// 或 https:// 地址: %s", p.URL)
+		}
+	}
+	if p.ClientTimeoutMS < 0 || p.DialTimeoutMS < 0 {
+		return errors.New("超时不能为负数")
+	}
+	if p.ClientTimeoutMS > 0 && p.DialTimeoutMS > p.ClientTimeoutMS {
+		return fmt.Errorf("dial_timeout_ms (%d) 不能大于 client_timeout_ms (%d)", p.DialTimeoutMS, p.ClientTimeoutMS)
+	}
+	switch strings.ToUpper(p.Method) {
+	case "", "GET", "HEAD":
+	default:
+		return fmt.Errorf("method 只能是 GET 或 HEAD: %s", p.Method)
+	}
+	for _, code := range p.AcceptedStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("无效的 HTTP 状态码: %d", code)
+		}
+	}
+	if p.MaxLossRate != nil && (*p.MaxLossRate < 0 || *p.MaxLossRate >= 1) {
+		return fmt.Errorf("max_loss_rate 必须在 [0, 1) 范围内: %g", *p.MaxLossRate)
+	}
+	if p.PingCount < 0 {
+		return fmt.Errorf("ping_count 不能为负数: %d", p.PingCount)
+	}
+	return nil
 }
 
 // LoadConfig 从指定路径加载和解析 YAML 配置文件
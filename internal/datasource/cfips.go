@@ -20,11 +20,17 @@ const (
 
 // IPNetSet 用于高效地检查 IP 是否属于某个范围
 type CFIPSet struct {
-	Nets []*net.IPNet
+	Nets     []*net.IPNet
+	Excluded []*net.IPNet // 排除的网段，优先级高于 Nets
 }
 
 // Contains 检查给定的 IP 是否在集合中
 func (s *CFIPSet) Contains(ip net.IP) bool {
+	for _, n := range s.Excluded {
+		if n.Contains(ip) {
+			return false
+		}
+	}
 	for _, n := range s.Nets {
 		if n.Contains(ip) {
 			return true
@@ -33,6 +39,54 @@ func (s *CFIPSet) Contains(ip net.IP) bool {
 	return false
 }
 
+// AddCIDRs 将额外的网段合并进集合，只保留与 ipVersion 相符的网段
+func (s *CFIPSet) AddCIDRs(cidrs []string, ipVersion string) error {
+	nets, err := parseCIDRs(cidrs, ipVersion)
+	if err != nil {
+		return err
+	}
+	s.Nets = append(s.Nets, nets...)
+	return nil
+}
+
+// ExcludeCIDRs 将网段加入排除列表，落在其中的 IP 不再被视为集合的一部分
+func (s *CFIPSet) ExcludeCIDRs(cidrs []string, ipVersion string) error {
+	nets, err := parseCIDRs(cidrs, ipVersion)
+	if err != nil {
+		return err
+	}
+	s.Excluded = append(s.Excluded, nets...)
+	return nil
+}
+
+// parseCIDRs 解析 CIDR 列表（单个 IP 视为 /32 或 /128），并丢弃与 ipVersion 不符的网段
+func parseCIDRs(cidrs []string, ipVersion string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 CIDR '%s': %w", cidr, err)
+		}
+		isV4 := ipNet.IP.To4() != nil
+		if (ipVersion == "ipv6" && isV4) || (ipVersion != "ipv6" && !isV4) {
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
 // LoadCFIPs 确保 Cloudflare IP 列表可用，并在必要时下载
 func LoadCFIPs(cachePath string, cfg *config.Config) (*CFIPSet, error) {
 	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
@@ -44,7 +98,19 @@ func LoadCFIPs(cachePath string, cfg *config.Config) (*CFIPSet, error) {
 		fmt.Println("下载并缓存成功。")
 	}
 
-	return loadIPsFromFile(cachePath)
+	ipSet, err := loadIPsFromFile(cachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// 合并配置中的额外网段与排除网段
+	if err := ipSet.AddCIDRs(cfg.ExtraCIDRs, cfg.IPVersion); err != nil {
+		return nil, fmt.Errorf("解析 extra_cidrs 失败: %w", err)
+	}
+	if err := ipSet.ExcludeCIDRs(cfg.ExcludeCIDRs, cfg.IPVersion); err != nil {
+		return nil, fmt.Errorf("解析 exclude_cidrs 失败: %w", err)
+	}
+	return ipSet, nil
 }
 
 func downloadAndCacheCFIPs(filePath string, cfg *config.Config) error {
@@ -0,0 +1,256 @@
+package datasource
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// StdinSource 表示从标准输入读取候选 IP
+	StdinSource = "-"
+	// ipv6SamplesPerCIDR IPv6 CIDR 中随机抽取的地址数量
+	ipv6SamplesPerCIDR = 16
+	// minIPv4ImportPrefix 是导入的 IPv4 CIDR 允许的最短前缀，/12 最多展开为 4096 个候选地址
+	minIPv4ImportPrefix = 12
+)
+
+// LoadImportedIPs 从文件、标准输入或 URL 中读取候选 IP，支持以下格式：
+//   - 每行一个 IP 或 CIDR 的纯文本列表（支持 '#' 注释）
+//   - CloudflareSpeedTest 的 result.csv
+//   - 本工具输出的 result_*.csv / result_*.json，其中的端口会被保留
+//
+// CIDR 会像 CloudflareSpeedTest 一样展开：IPv4 每个 /24 随机取一个地址，IPv6 每段随机取若干地址。
+// 短于 /12 的 IPv4 CIDR 展开后的候选过多，会被拒绝。
+func LoadImportedIPs(sources []string) ([]model.IPInfo, error) {
+	var ips []model.IPInfo
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+		data, err := readImportSource(source)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseImportData(data, importLabel(source))
+		if err != nil {
+			return nil, fmt.Errorf("解析导入源 '%s' 失败: %w", source, err)
+		}
+		ips = append(ips, parsed...)
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("导入源中未找到有效的 IP 或 CIDR")
+	}
+	return ips, nil
+}
+
+// readImportSource 根据来源类型读取原始数据
+func readImportSource(source string) ([]byte, error) {
+	switch {
+	case source == StdinSource:
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("读取标准输入失败: %w", err)
+		}
+		return data, nil
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		data, err := downloadURL(source)
+		if err != nil {
+			return nil, fmt.Errorf("下载导入列表 '%s' 失败: %w", source, err)
+		}
+		return data, nil
+	default:
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取导入文件 '%s': %w", source, err)
+		}
+		return data, nil
+	}
+}
+
+// importLabel 生成写入 SourceDomain 字段的来源标记
+func importLabel(source string) string {
+	if source == StdinSource {
+		return "import:stdin"
+	}
+	return "import:" + source
+}
+
+// parseImportData 自动识别数据格式并提取 IP
+func parseImportData(data []byte, label string) ([]model.IPInfo, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return parseImportJSON(trimmed, label)
+	}
+
+	var ips []model.IPInfo
+	portColumn := -1
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+		if line == "" {
+			continue
+		}
+
+		// CSV 格式（CloudflareSpeedTest 及本工具的结果文件）的 IP 都在第一列，本工具的结果文件另有 Port 列
+		var fields []string
+		port := 0
+		if strings.Contains(line, ",") {
+			columns := strings.Split(line, ",")
+			if isCSVHeader(columns) {
+				portColumn = findPortColumn(columns)
+				continue
+			}
+			fields = columns[:1]
+			if portColumn >= 0 && portColumn < len(columns) {
+				port = parsePort(columns[portColumn])
+			}
+		} else {
+			fields = strings.Fields(line)
+		}
+		for _, field := range fields {
+			expanded, err := expandImportEntry(strings.Trim(field, "\" "), label, port)
+			if err != nil {
+				return nil, err
+			}
+			ips = append(ips, expanded...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ips, nil
+}
+
+// isCSVHeader 判断 CSV 行是否为表头（第一列不是 IP 或 CIDR）
+func isCSVHeader(columns []string) bool {
+	first := strings.Trim(columns[0], "\" ")
+	if net.ParseIP(first) != nil {
+		return false
+	}
+	_, _, err := net.ParseCIDR(first)
+	return err != nil
+}
+
+// findPortColumn 返回表头中端口列的位置，没有端口列时返回 -1
+func findPortColumn(columns []string) int {
+	for i, column := range columns {
+		if strings.EqualFold(strings.Trim(column, "\" "), "Port") {
+			return i
+		}
+	}
+	return -1
+}
+
+// parsePort 解析端口，无效时返回 0（使用配置的端口）
+func parsePort(s string) int {
+	port, err := strconv.Atoi(strings.Trim(s, "\" "))
+	if err != nil || port < 1 || port > 65535 {
+		return 0
+	}
+	return port
+}
+
+// parseImportJSON 解析本工具输出的 result_*.json（对象数组，IP 位于 Address 字段，端口位于 Port 字段）
+func parseImportJSON(data []byte, label string) ([]model.IPInfo, error) {
+	var entries []map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	var ips []model.IPInfo
+	for _, entry := range entries {
+		port := 0
+		if p, ok := entry["Port"].(float64); ok && p >= 1 && p <= 65535 {
+			port = int(p)
+		}
+		for _, key := range []string{"Address", "address", "IP", "ip"} {
+			if addr, ok := entry[key].(string); ok {
+				expanded, err := expandImportEntry(addr, label, port)
+				if err != nil {
+					return nil, err
+				}
+				ips = append(ips, expanded...)
+				break
+			}
+		}
+	}
+	return ips, nil
+}
+
+// expandImportEntry 将单个 IP 或 CIDR 转换为候选 IP 列表，port 为 0 时测试配置的端口。
+// 无法解析时返回 nil，CIDR 过大时返回错误。
+func expandImportEntry(entry, label string, port int) ([]model.IPInfo, error) {
+	if ip := net.ParseIP(entry); ip != nil {
+		return []model.IPInfo{{Address: ip, Port: port, SourceDomain: label}}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, nil
+	}
+	if ones, _ := ipNet.Mask.Size(); ipNet.IP.To4() != nil && ones < minIPv4ImportPrefix {
+		return nil, fmt.Errorf("CIDR %s 过大，IPv4 前缀不能短于 /%d", entry, minIPv4ImportPrefix)
+	}
+
+	var ips []model.IPInfo
+	for _, ip := range sampleCIDR(ipNet) {
+		ips = append(ips, model.IPInfo{Address: ip, Port: port, SourceDomain: label})
+	}
+	return ips, nil
+}
+
+// sampleCIDR 从 CIDR 中抽取候选地址
+func sampleCIDR(ipNet *net.IPNet) []net.IP {
+	ones, bits := ipNet.Mask.Size()
+	if ip4 := ipNet.IP.To4(); ip4 != nil {
+		// 小于等于 /24 的网段每个 /24 取一个随机地址
+		if ones >= 24 {
+			return []net.IP{randomIPInNet(ip4, ones, bits)}
+		}
+		base := binary.BigEndian.Uint32(ip4)
+		count := uint32(1) << (24 - ones)
+		ips := make([]net.IP, 0, count)
+		for i := uint32(0); i < count; i++ {
+			subnet := make(net.IP, 4)
+			binary.BigEndian.PutUint32(subnet, base+i<<8)
+			ips = append(ips, randomIPInNet(subnet, 24, bits))
+		}
+		return ips
+	}
+
+	samples := ipv6SamplesPerCIDR
+	if bits-ones < 8 {
+		samples = 1
+	}
+	ips := make([]net.IP, 0, samples)
+	for i := 0; i < samples; i++ {
+		ips = append(ips, randomIPInNet(ipNet.IP, ones, bits))
+	}
+	return ips
+}
+
+// randomIPInNet 在给定前缀内随机生成一个主机地址
+func randomIPInNet(base net.IP, ones, bits int) net.IP {
+	ip := make(net.IP, len(base))
+	copy(ip, base)
+	for i := ones; i < bits; i++ {
+		if rand.Intn(2) == 1 {
+			ip[i/8] |= 1 << (7 - uint(i%8))
+		}
+	}
+	return ip
+}
@@ -1,19 +1,30 @@
 package engine
 
 import (
+	"Domain_IP_Selector_Go/internal/bgp"
 	"Domain_IP_Selector_Go/internal/config"
 	"Domain_IP_Selector_Go/internal/datasource"
 	"Domain_IP_Selector_Go/internal/locations"
+	"Domain_IP_Selector_Go/internal/pool"
+	"Domain_IP_Selector_Go/internal/quarantine"
+	"Domain_IP_Selector_Go/internal/speedurl"
 	"Domain_IP_Selector_Go/internal/tester"
+	"Domain_IP_Selector_Go/internal/usage"
 	"Domain_IP_Selector_Go/pkg/model"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net"
+	"net/url"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -23,18 +34,80 @@ type ProgressCallback func(message string)
 // Run 启动 IP 优选引擎
 // SimplifiedResult 定义了最终输出的扁平化数据结构
 type SimplifiedResult struct {
-	Address       string  `json:"Address"`
-	SourceDomain  string  `json:"SourceDomain"`
-	Delay         int64   `json:"Delay"` // 纳秒
-	LossRate      float64 `json:"LossRate"`
-	Colo          string  `json:"Colo"`
-	Region        string  `json:"Region"`
-	DownloadSpeed int     `json:"DownloadSpeed"` // MB/s
+	Address           string  `json:"Address"`
+	Port              int     `json:"Port"`
+	SourceDomain      string  `json:"SourceDomain"`
+	Delay             int64   `json:"Delay"`       // 纳秒
+	DelayCILow        int64   `json:"DelayCILow"`  // 平均延迟 95% 置信区间下限，纳秒
+	DelayCIHigh       int64   `json:"DelayCIHigh"` // 平均延迟 95% 置信区间上限，纳秒
+	Samples           int     `json:"Samples"`     // 成功的延迟样本数
+	MinDelay          int64   `json:"MinDelay"`
+	MedianDelay       int64   `json:"MedianDelay"`
+	P90Delay          int64   `json:"P90Delay"`
+	MaxDelay          int64   `json:"MaxDelay"`
+	Jitter            int64   `json:"Jitter"`
+	ConnectTime       int64   `json:"ConnectTime"`
+	TLSTime           int64   `json:"TLSTime"`
+	TTFB              int64   `json:"TTFB"`
+	LossRate          float64 `json:"LossRate"`
+	Colo              string  `json:"Colo"`
+	Region            string  `json:"Region"`
+	ClientIP          string  `json:"ClientIP"`      // Cloudflare 观察到的客户端公网 IP
+	Country           string  `json:"Country"`       // 客户端所在国家/地区代码
+	HTTPVersion       string  `json:"HTTPVersion"`   // 协商的 HTTP 版本
+	TLSVersion        string  `json:"TLSVersion"`    // 协商的 TLS 版本
+	Prefix            string  `json:"Prefix"`        // BGP 宣告前缀
+	OriginASN         uint32  `json:"OriginASN"`     // 源 ASN
+	DownloadSpeed     int     `json:"DownloadSpeed"` // MB/s
+	UploadSpeed       int     `json:"UploadSpeed"`   // 上传速度，KB/s，未启用或失败时为 0
+	StreamSpeeds      []int   `json:"StreamSpeeds"`  // 多连接测速时每个连接的下载速度，KB/s
+	QUICDelay         int64   `json:"QUICDelay"`     // HTTP/3 延迟，纳秒，未启用或失败时为 0
+	QUICLossRate      float64 `json:"QUICLossRate"`
+	QUICDownloadSpeed int     `json:"QUICDownloadSpeed"` // HTTP/3 下载速度，KB/s
+	KernelRTT         int64   `json:"KernelRTT"`         // 测速连接的内核 RTT，纳秒，仅 Linux
+	KernelRTTVar      int64   `json:"KernelRTTVar"`      // 内核 RTT 方差，纳秒
+	Retransmits       uint32  `json:"Retransmits"`       // 测速连接重传的数据段
+	LostSegments      uint32  `json:"LostSegments"`      // 测速连接丢失的数据段
+	OutOfOrder        uint32  `json:"OutOfOrder"`        // 测速连接收到的乱序数据段
+	DeliveryRate      int     `json:"DeliveryRate"`      // 内核交付速率，KB/s
+	TCPLossRatio      float64 `json:"TCPLossRatio"`      // 重传、丢失与乱序数据段占比
+	LoadedDelay       int64   `json:"LoadedDelay"`       // 下载期间的 TCP 握手延迟中位数，纳秒，未启用或失败时为 0
+	LatencyIncrease   int64   `json:"LatencyIncrease"`   // 负载延迟相对空载延迟的增量，纳秒
+	BufferbloatGrade  string  `json:"BufferbloatGrade"`  // 缓冲膨胀等级（A+ 到 F），未测量时为空
+	SpeedURL          string  `json:"SpeedURL"`          // 得出下载速度实际请求的测速地址（明文端口为 http_speed_url 或改为 http:// 的地址）
+	Throughput        []int   `json:"Throughput"`        // 下载测速每个时间片的吞吐量，KB/s
+	BurstSpeed        int     `json:"BurstSpeed"`        // 测速开始阶段的突发速度，KB/s
+	SustainedSpeed    int     `json:"SustainedSpeed"`    // 测速后半段的持续速度，KB/s
+	Stalls            int     `json:"Stalls"`            // 下载过程中的停顿次数
+	ShapingPattern    string  `json:"ShapingPattern"`    // 检测到的限速形态（burst-throttle、stalls），正常时为空
+	DataUsage         int64   `json:"DataUsage"`         // 本次运行中与该 IP 之间传输的总字节数（所有阶段与端口）
+	UploadRetransmits uint32  `json:"UploadRetransmits"` // 上传测速连接重传的数据段
+	UploadLossRatio   float64 `json:"UploadLossRatio"`   // 上传测速连接的重传、丢失与乱序数据段占比
 }
 
-func Run(cfg *config.Config, locationsPath, domainsPath, exeDir string, progressCb ProgressCallback) ([]SimplifiedResult, error) {
+// Run 执行一次完整的优选流程。meter 不为 nil 时统计 DNS、延迟与测速各阶段以及每个 IP 的流量。
+func Run(cfg *config.Config, locationsPath, domainsPath, exeDir string, meter *usage.Meter, progressCb ProgressCallback) ([]SimplifiedResult, error) {
 	// --- 1. 初始化 ---
 	progressCb("步骤 1/5: 初始化数据源...")
+	if err := cfg.LatencyProfile.Validate(); err != nil {
+		return nil, fmt.Errorf("latency_profile 配置无效: %w", err)
+	}
+	if cfg.LatencyMinSamples > 0 && cfg.LatencyMaxSamples > 0 && cfg.LatencyMinSamples > cfg.LatencyMaxSamples {
+		return nil, fmt.Errorf("latency_min_samples (%d) 不能大于 latency_max_samples (%d)", cfg.LatencyMinSamples, cfg.LatencyMaxSamples)
+	}
+	if cfg.SpeedTestMinDuration > 0 && cfg.SpeedTestMaxDuration > 0 && cfg.SpeedTestMinDuration > cfg.SpeedTestMaxDuration {
+		return nil, fmt.Errorf("speedtest_min_duration_ms (%d) 不能大于 speedtest_max_duration_ms (%d)", cfg.SpeedTestMinDuration, cfg.SpeedTestMaxDuration)
+	}
+	if cfg.MaxBufferbloatGrade != "" {
+		cfg.MaxBufferbloatGrade = strings.ToUpper(cfg.MaxBufferbloatGrade)
+		if _, ok := tester.BufferbloatGradeRank(cfg.MaxBufferbloatGrade); !ok {
+			return nil, fmt.Errorf("max_bufferbloat_grade 无效: %q，可选值为 A+、A、B、C、D、F", cfg.MaxBufferbloatGrade)
+		}
+	}
+	cfg.TLSFingerprint = strings.ToLower(cfg.TLSFingerprint)
+	if err := tester.ValidateFingerprint(cfg.TLSFingerprint); err != nil {
+		return nil, fmt.Errorf("tls_fingerprint 配置无效: %w", err)
+	}
 	regionMap, err := locations.LoadLocationsFromFile(locationsPath)
 	if err != nil {
 		return nil, fmt.Errorf("加载 locations.json 失败: %w", err)
@@ -55,47 +128,288 @@ func Run(cfg *config.Config, locationsPath, domainsPath, exeDir string, progress
 	if err != nil {
 		return nil, fmt.Errorf("加载 Cloudflare IP 列表失败: %w", err)
 	}
+
+	var ipPool *pool.Pool
+	if cfg.PoolEnabled {
+		ipPool, err = loadPool(cfg, ipVersion, exeDir, progressCb)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var bgpTable *bgp.Table
+	if cfg.BGPTableFile != "" {
+		tablePath := cfg.BGPTableFile
+		if !filepath.IsAbs(tablePath) {
+			tablePath = filepath.Join(exeDir, tablePath)
+		}
+		bgpTable, err = bgp.LoadTableFromFile(tablePath)
+		if err != nil {
+			return nil, fmt.Errorf("加载 BGP 路由表失败: %w", err)
+		}
+		progressCb(fmt.Sprintf("BGP 路由表加载完成，共 %d 个前缀。", bgpTable.Len()))
+	}
+
+	var quarantineList *quarantine.List
+	if cfg.QuarantineEnabled {
+		quarantineList, err = loadQuarantine(cfg, ipVersion, exeDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, port := range cfg.QUICPorts {
+		if port < 1 || port > 65535 || !tester.IsTLSPort(port) {
+			return nil, fmt.Errorf("quic_ports 中的端口 %d 无效，HTTP/3 只能在 TLS 端口上测试", port)
+		}
+	}
+	if cfg.RankBy == rankByQUIC && !cfg.QUICEnabled {
+		progressCb("警告: rank_by 为 quic 但未启用 quic_enabled，已自动启用 HTTP/3 测试。")
+		cfg.QUICEnabled = true
+	}
+	if cfg.RankBy == rankByUpload && !cfg.UploadEnabled {
+		progressCb("警告: rank_by 为 upload 但未启用 upload_enabled，已自动启用上传测速。")
+		cfg.UploadEnabled = true
+	}
+	if cfg.MaxBufferbloatGrade != "" && !cfg.LoadedLatencyEnabled {
+		progressCb("警告: 设置了 max_bufferbloat_grade 但未启用 loaded_latency_enabled，已自动启用负载延迟测量。")
+		cfg.LoadedLatencyEnabled = true
+	}
+	if cfg.SpeedTestTolerance > 0 && shapingThrottleRatio(cfg) > 0 {
+		progressCb("注意: 检测突发后限速需要完整的吞吐量曲线，speedtest_tolerance 不生效，下载测速将测满最长时长。")
+	}
+	if cfg.RankBy == rankByLoss && runtime.GOOS != "linux" {
+		progressCb("警告: rank_by 为 loss 需要 Linux 的 TCP_INFO，当前平台上将退化为按延迟与下载速度排序。")
+	}
+	session := &tester.Session{Meter: meter, Fingerprint: cfg.TLSFingerprint}
 	progressCb("初始化完成。")
 
-	// --- 2. DNS 解析与 IP 筛选 ---
-	progressCb("步骤 2/5: DNS 解析与 IP 筛选...")
-	domains, err := datasource.LoadDomainsFromFile(domainsPath)
-	if err != nil {
-		return nil, fmt.Errorf("加载域名列表失败: %w", err)
+	// --- 2. DNS 解析（或导入）与 IP 筛选 ---
+	var initialIPs []model.IPInfo
+	if len(cfg.ImportSources) > 0 {
+		progressCb("步骤 2/5: 导入候选 IP 与筛选（跳过 DNS 解析）...")
+		initialIPs, err = datasource.LoadImportedIPs(cfg.ImportSources)
+		if err != nil {
+			return nil, fmt.Errorf("导入候选 IP 失败: %w", err)
+		}
+		progressCb(fmt.Sprintf("从 %d 个导入源读取到 %d 个候选 IP。", len(cfg.ImportSources), len(initialIPs)))
+	} else {
+		progressCb("步骤 2/5: DNS 解析与 IP 筛选...")
+		domains, err := datasource.LoadDomainsFromFile(domainsPath)
+		if err != nil {
+			return nil, fmt.Errorf("加载域名列表失败: %w", err)
+		}
+		meter.SetStage(usage.StageDNS)
+		initialIPs = resolveDomains(domains, cfg, meter, progressCb)
+		reportStageUsage(meter, usage.StageDNS, progressCb)
+	}
+
+	if ipPool.Len() > 0 {
+		initialIPs = append(initialIPs, ipPool.Candidates()...)
+		progressCb(fmt.Sprintf("已从优质 IP 池合并 %d 个候选 IP。", ipPool.Len()))
 	}
 
-	initialIPs := resolveDomains(domains, cfg, progressCb)
 	uniqueIPs := deduplicateIPs(initialIPs)
 	cfIPs := filterCloudflareIPs(uniqueIPs, cfIPSet)
 	progressCb(fmt.Sprintf("筛选出 %d 个 Cloudflare IP 地址。", len(cfIPs)))
 
+	if bgpTable != nil {
+		annotatePrefixes(cfIPs, bgpTable)
+		if len(cfg.FilterPrefixes) > 0 {
+			cfIPs = filterPrefixes(cfIPs, cfg.FilterPrefixes)
+			progressCb(fmt.Sprintf("按宣告前缀筛选后剩余 %d 个 IP。", len(cfIPs)))
+		}
+	}
+
+	ports, err := resolvePorts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PlainHTTP {
+		progressCb("已启用明文 HTTP 模式，所有测试均不使用 TLS。")
+	} else if cfg.TLSFingerprint != "" {
+		progressCb(fmt.Sprintf("延迟与速度测试将使用 %s 浏览器的 TLS 指纹。", cfg.TLSFingerprint))
+		if cfg.QUICEnabled {
+			progressCb("注意: HTTP/3（QUIC）测试不支持模拟浏览器 TLS 指纹，仍使用默认的 TLS 握手。")
+		}
+	}
+	if cfg.UploadEnabled && cfg.HTTPUploadURL == "" && slices.ContainsFunc(ports, func(port int) bool { return !tester.IsTLSPort(port) }) {
+		progressCb("注意: 未配置 http_upload_url，明文端口上将跳过上传测速。")
+	}
+	cfIPs = expandPorts(cfIPs, ports)
+	if cfg.PlainHTTP {
+		var dropped int
+		cfIPs, dropped = dropTLSPorts(cfIPs)
+		if dropped > 0 {
+			progressCb(fmt.Sprintf("明文 HTTP 模式下跳过了 %d 个导入时指定了 TLS 端口的候选。", dropped))
+		}
+	}
+	if quarantineList != nil {
+		// 隔离按端口记录，需要在展开端口后过滤
+		var skipped int
+		cfIPs, skipped = quarantineList.Filter(cfIPs)
+		progressCb(fmt.Sprintf("已跳过 %d 个处于隔离状态的 IP:端口 组合。", skipped))
+	}
+	if len(ports) > 1 {
+		progressCb(fmt.Sprintf("将在 %d 个端口 %v 上测试，共 %d 个 IP:端口 组合。", len(ports), ports, len(cfIPs)))
+	}
+
 	// --- 3. 延迟测试 ---
 	progressCb("步骤 3/5: 延迟测试...")
-	latencyResults := testLatencies(cfIPs, cfg, regionMap, progressCb)
+	meter.SetStage(usage.StageLatency)
+	latencyCandidates := cfIPs
+	if cfg.ScreenMode != "" {
+		latencyCandidates = screenCandidates(cfIPs, cfg, session, progressCb)
+	}
+	latencyResults := testLatencies(latencyCandidates, cfg, session, regionMap, quarantineList, progressCb)
 	progressCb("延迟测试完成。")
+	reportStageUsage(meter, usage.StageLatency, progressCb)
+	recordPoolLatencyFailures(ipPool, latencyCandidates, latencyResults)
 
 	// --- 4. 过滤与分组 ---
 	progressCb("步骤 4/5: 过滤与分组...")
 	filteredResults := filterResults(latencyResults, cfg)
-	groupedResults := groupResults(filteredResults, cfg.GroupBy)
+	groupedResults := groupResults(filteredResults, cfg.GroupBy, cfg.RankBy)
 	progressCb(fmt.Sprintf("已将 IP 按 '%s' 分为 %d 组。", cfg.GroupBy, len(groupedResults)))
 
 	// --- 5. 下载速度测试 (带补充逻辑) ---
 	progressCb("步骤 5/5: 下载速度测试...")
-	finalResults := testSpeedsWithRetry(groupedResults, cfg, progressCb)
+	meter.SetStage(usage.StageSpeed)
+	speedURLs := newSpeedURLChain(cfg, session, progressCb)
+	finalResults := testSpeedsWithRetry(groupedResults, cfg, session, speedURLs, ipPool, quarantineList, progressCb)
 	progressCb("速度测试完成。")
+	reportSpeedURLStats(speedURLs, progressCb)
+	reportStageUsage(meter, usage.StageSpeed, progressCb)
+
+	if ipPool != nil {
+		if err := ipPool.Save(); err != nil {
+			progressCb(fmt.Sprintf("警告: 保存优质 IP 池失败: %v", err))
+		} else {
+			progressCb(fmt.Sprintf("优质 IP 池已更新，当前共 %d 个 IP。", ipPool.Len()))
+		}
+	}
+	if err := quarantineList.Save(); err != nil {
+		progressCb(fmt.Sprintf("警告: 保存隔离列表失败: %v", err))
+	}
 
 	// 按下载速度倒序排序
 	sort.Slice(finalResults, func(i, j int) bool {
+		if cfg.RankBy == rankByQUIC {
+			return finalResults[i].QUICDownloadSpeed > finalResults[j].QUICDownloadSpeed
+		}
+		if cfg.RankBy == rankByUpload {
+			return finalResults[i].UploadSpeed > finalResults[j].UploadSpeed
+		}
+		if cfg.RankBy == rankByLoss && finalResults[i].TCPLossRatio != finalResults[j].TCPLossRatio {
+			return finalResults[i].TCPLossRatio < finalResults[j].TCPLossRatio
+		}
 		return finalResults[i].DownloadSpeed > finalResults[j].DownloadSpeed
 	})
 
+	if bgpTable != nil {
+		reportByPrefix(finalResults, progressCb)
+	}
+	if meter != nil {
+		for i := range finalResults {
+			finalResults[i].DataUsage = meter.IP(finalResults[i].Address).Total()
+		}
+		reportUsage(meter.Report(), progressCb)
+	}
+
 	return finalResults, nil
 }
 
 // --- 各阶段的具体实现 ---
 
-func resolveDomains(domains []string, cfg *config.Config, progressCb ProgressCallback) []model.IPInfo {
+// loadPool 加载优质 IP 池并清理过期条目
+func loadPool(cfg *config.Config, ipVersion, exeDir string, progressCb ProgressCallback) (*pool.Pool, error) {
+	if cfg.PoolMaxAgeDays <= 0 {
+		cfg.PoolMaxAgeDays = 30
+	}
+	if cfg.PoolMaxFailures <= 0 {
+		cfg.PoolMaxFailures = 3
+	}
+	if cfg.PoolHalfLifeDays <= 0 {
+		cfg.PoolHalfLifeDays = 7
+	}
+
+	poolFile := filepath.Join(exeDir, fmt.Sprintf("ip_pool_%s.json", ipVersion))
+	halfLife := time.Duration(cfg.PoolHalfLifeDays * float64(24*time.Hour))
+	ipPool, err := pool.Load(poolFile, halfLife)
+	if err != nil {
+		return nil, fmt.Errorf("加载优质 IP 池失败: %w", err)
+	}
+
+	maxAge := time.Duration(cfg.PoolMaxAgeDays) * 24 * time.Hour
+	if removed := ipPool.Prune(maxAge, cfg.PoolMaxFailures); removed > 0 {
+		progressCb(fmt.Sprintf("已从优质 IP 池清理 %d 个过期或失效的 IP。", removed))
+	}
+	progressCb(fmt.Sprintf("优质 IP 池加载完成，共 %d 个 IP。", ipPool.Len()))
+	return ipPool, nil
+}
+
+// loadQuarantine 加载隔离列表，未配置的阈值使用默认值
+func loadQuarantine(cfg *config.Config, ipVersion, exeDir string) (*quarantine.List, error) {
+	if cfg.QuarantineTimeoutStrikes == 0 {
+		cfg.QuarantineTimeoutStrikes = 3
+	}
+	if cfg.QuarantineStatusStrikes == 0 {
+		cfg.QuarantineStatusStrikes = 2
+	}
+	if cfg.QuarantineLowSpeedStrikes == 0 {
+		cfg.QuarantineLowSpeedStrikes = 2
+	}
+	if cfg.QuarantineLowSpeedMB <= 0 {
+		cfg.QuarantineLowSpeedMB = 0.1
+	}
+	if cfg.QuarantineExpireHours <= 0 {
+		cfg.QuarantineExpireHours = 72
+	}
+
+	thresholds := map[quarantine.Kind]int{
+		quarantine.KindTimeout:  cfg.QuarantineTimeoutStrikes,
+		quarantine.KindStatus:   cfg.QuarantineStatusStrikes,
+		quarantine.KindLowSpeed: cfg.QuarantineLowSpeedStrikes,
+	}
+	quarantineFile := filepath.Join(exeDir, fmt.Sprintf("quarantine_%s.json", ipVersion))
+	list, err := quarantine.Load(quarantineFile, thresholds, time.Duration(cfg.QuarantineExpireHours)*time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("加载隔离列表失败: %w", err)
+	}
+	return list, nil
+}
+
+// classifyFailure 将测试错误归类为隔离列表的失败类型，超时、连接被重置等连接失败都按超时处理
+func classifyFailure(err error) quarantine.Kind {
+	if errors.Is(err, tester.ErrHTTPSRedirect) {
+		return "" // 测速地址配置问题，与 IP 无关，不计入隔离
+	}
+	if errors.Is(err, tester.ErrInvalidStatus) {
+		return quarantine.KindStatus
+	}
+	return quarantine.KindTimeout
+}
+
+// recordPoolLatencyFailures 将本轮参与延迟测试但在所有端口上都未通过的池中 IP 记为一次失败
+func recordPoolLatencyFailures(ipPool *pool.Pool, tested []model.IPInfo, passed []model.LatencyResult) {
+	if ipPool == nil {
+		return
+	}
+	passedSet := make(map[string]bool, len(passed))
+	for _, res := range passed {
+		passedSet[res.IPInfo.Address.String()] = true
+	}
+	recorded := make(map[string]bool)
+	for _, ipInfo := range tested {
+		addr := ipInfo.Address.String()
+		if passedSet[addr] || recorded[addr] || !ipPool.Contains(ipInfo.Address) {
+			continue
+		}
+		recorded[addr] = true
+		ipPool.RecordFailure(ipInfo.Address)
+	}
+}
+
+func resolveDomains(domains []string, cfg *config.Config, meter *usage.Meter, progressCb ProgressCallback) []model.IPInfo {
 	var (
 		initialIPs []model.IPInfo
 		wg         sync.WaitGroup
@@ -113,7 +427,11 @@ func resolveDomains(domains []string, cfg *config.Config, progressCb ProgressCal
 		PreferGo: true,
 		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
 			d := net.Dialer{Timeout: 5 * time.Second} // 为拨号本身也增加超时
-			return d.DialContext(ctx, "udp", "1.1.1.1:53")
+			conn, err := d.DialContext(ctx, "udp", "1.1.1.1:53")
+			if err != nil {
+				return nil, err
+			}
+			return meter.WrapConn(conn), nil
 		},
 	}
 	dnsSemaphore := make(chan struct{}, cfg.DNSConcurrency)
@@ -161,9 +479,9 @@ func resolveDomains(domains []string, cfg *config.Config, progressCb ProgressCal
 func deduplicateIPs(ips []model.IPInfo) []model.IPInfo {
 	uniqueIPsMap := make(map[string]model.IPInfo)
 	for _, ipInfo := range ips {
-		ipStr := ipInfo.Address.String()
-		if _, exists := uniqueIPsMap[ipStr]; !exists {
-			uniqueIPsMap[ipStr] = ipInfo
+		key := ipInfo.Endpoint() // 导入时指定了不同端口的同一 IP 分别保留
+		if _, exists := uniqueIPsMap[key]; !exists {
+			uniqueIPsMap[key] = ipInfo
 		}
 	}
 	uniqueIPs := make([]model.IPInfo, 0, len(uniqueIPsMap))
@@ -183,7 +501,382 @@ func filterCloudflareIPs(ips []model.IPInfo, cfIPSet *datasource.CFIPSet) []mode
 	return cfIPs
 }
 
-func testLatencies(ips []model.IPInfo, cfg *config.Config, regionMap locations.RegionMap, progressCb ProgressCallback) []model.LatencyResult {
+// resolvePorts 返回待测端口列表并校验其与明文 HTTP 模式的配置是否一致
+func resolvePorts(cfg *config.Config) ([]int, error) {
+	ports := cfg.Ports
+	if len(ports) == 0 {
+		ports = []int{tester.DefaultTCPPort} // 默认只测试 443
+		if cfg.PlainHTTP {
+			ports = []int{tester.DefaultHTTPPort}
+		}
+	}
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("无效的端口配置: %d", port)
+		}
+		if cfg.PlainHTTP && tester.IsTLSPort(port) {
+			return nil, fmt.Errorf("明文 HTTP 模式下不能测试 TLS 端口 %d", port)
+		}
+	}
+	for name, u := range map[string]string{"http_latency_url": cfg.HTTPLatencyURL, "http_speed_url": cfg.HTTPSpeedURL, "http_upload_url": cfg.HTTPUploadURL} {
+		if u != "" && !strings.HasPrefix(u, "http://") {
+			return nil, fmt.Errorf("%s 必须是 http:// 地址: %s", name, u)
+		}
+	}
+	return ports, nil
+}
+
+// expandPorts 为每个 IP 生成每个待测端口的候选，导入时已指定端口的候选保持不变
+func expandPorts(ips []model.IPInfo, ports []int) []model.IPInfo {
+	expanded := make([]model.IPInfo, 0, len(ips)*len(ports))
+	for _, ipInfo := range ips {
+		if ipInfo.Port != 0 {
+			expanded = append(expanded, ipInfo)
+			continue
+		}
+		for _, port := range ports {
+			ipInfo.Port = port
+			expanded = append(expanded, ipInfo)
+		}
+	}
+	return expanded
+}
+
+// dropTLSPorts 移除使用 TLS 端口的候选，返回剩余的候选与移除的数量
+func dropTLSPorts(ips []model.IPInfo) ([]model.IPInfo, int) {
+	kept := ips[:0]
+	for _, ipInfo := range ips {
+		if !tester.IsTLSPort(ipInfo.Port) {
+			kept = append(kept, ipInfo)
+		}
+	}
+	return kept, len(ips) - len(kept)
+}
+
+// annotatePrefixes 使用路由表为每个候选 IP 标注宣告前缀与源 ASN
+func annotatePrefixes(ips []model.IPInfo, table *bgp.Table) {
+	for i := range ips {
+		if route, ok := table.Lookup(ips[i].Address); ok {
+			ips[i].Prefix = route.Prefix.String()
+			ips[i].OriginASN = route.OriginASN
+		}
+	}
+}
+
+// filterPrefixes 只保留宣告前缀位于指定网段内的 IP
+func filterPrefixes(ips []model.IPInfo, allowed []string) []model.IPInfo {
+	var nets []*net.IPNet
+	for _, cidr := range allowed {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, ipNet)
+		} else {
+			log.Printf("警告: 无法解析 filter_prefixes 中的 '%s'，已忽略", cidr)
+		}
+	}
+
+	var filtered []model.IPInfo
+	for _, ipInfo := range ips {
+		if ipInfo.Prefix == "" {
+			continue
+		}
+		_, prefix, err := net.ParseCIDR(ipInfo.Prefix)
+		if err != nil {
+			continue
+		}
+		prefixLen, _ := prefix.Mask.Size()
+		for _, n := range nets {
+			if nLen, _ := n.Mask.Size(); n.Contains(prefix.IP) && prefixLen >= nLen {
+				filtered = append(filtered, ipInfo)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// reportByPrefix 按宣告前缀汇总最终结果
+func reportByPrefix(results []SimplifiedResult, progressCb ProgressCallback) {
+	type prefixStats struct {
+		prefix    string
+		asn       uint32
+		count     int
+		bestSpeed int
+	}
+	statsMap := make(map[string]*prefixStats)
+	var order []*prefixStats
+	for _, r := range results { // results 已按速度倒序，第一个即最快
+		st, ok := statsMap[r.Prefix]
+		if !ok {
+			st = &prefixStats{prefix: r.Prefix, asn: r.OriginASN, bestSpeed: r.DownloadSpeed}
+			statsMap[r.Prefix] = st
+			order = append(order, st)
+		}
+		st.count++
+	}
+
+	progressCb("按宣告前缀汇总:")
+	for _, st := range order {
+		prefix := st.prefix
+		if prefix == "" {
+			prefix = "Unknown"
+		}
+		progressCb(fmt.Sprintf("  %s (AS%d): %d 个 IP, 最高速度 %.2f MB/s", prefix, st.asn, st.count, float64(st.bestSpeed)/1024.0))
+	}
+}
+
+// 延迟测试模式
+const (
+	latencyModeHTTPing = "httping" // 多次 HTTPS HEAD 请求（默认）
+	latencyModeTCPing  = "tcping"  // 多次 TCP 握手，Colo 由一次 HTTP 请求获取
+	latencyModeHybrid  = "hybrid"  // 先用 TCPing 预筛，通过的 IP 再进行 HTTPing
+
+	latencyTestURL = "https://www.cloudflare.com/cdn-cgi/trace"
+)
+
+// 预筛方式
+const (
+	screenModeTCP = "tcp" // 单次 TCP 握手
+	screenModeTLS = "tls" // 单次 TCP + TLS 握手，明文端口退化为 TCP
+)
+
+// 排序依据
+const (
+	rankByDownload = "download" // TCP 下载速度与延迟（默认）
+	rankByQUIC     = "quic"     // HTTP/3 下载速度与延迟，需要启用 quic_enabled
+	rankByLoss     = "loss"     // 内核统计的重传与丢包比例优先，其次为延迟与下载速度（仅 Linux）
+	rankByUpload   = "upload"   // 上传速度，需要启用 upload_enabled
+
+	defaultSpeedURL  = "https://speed.cloudflare.com/__down?bytes=200000000"
+	fallbackSpeedURL = "https://cf.xiu2.xyz/url"
+	defaultUploadURL = "https://speed.cloudflare.com/__up"
+)
+
+var (
+	// errSpeedURLUnavailable 表示所有测速地址都因自身问题（错误状态码或重定向）失败或未配置，与被测 IP 无关
+	errSpeedURLUnavailable = errors.New("没有可用的测速地址")
+	// errNoHTTPUploadURL 表示明文端口上没有可用的上传测速地址
+	errNoHTTPUploadURL = fmt.Errorf("%w: 明文端口未配置 http_upload_url，无法测试上传速度", errSpeedURLUnavailable)
+)
+
+// latencyProfile 是填充默认值后的 latency_profile
+type latencyProfile struct {
+	url            string
+	probe          tester.Probe
+	maxLossRate    float64
+	pingCount      int // TCPing 延迟测试的固定请求次数
+	prescreenCount int // hybrid 模式中 TCPing 预筛的请求次数
+}
+
+// resolveLatencyProfile 为 latency_profile 中未设置的字段填充默认值，调用前应已通过 Validate
+func resolveLatencyProfile(p config.LatencyProfile) latencyProfile {
+	resolved := latencyProfile{
+		url:            latencyTestURL,
+		probe:          tester.DefaultProbe,
+		maxLossRate:    0.1,
+		pingCount:      4,
+		prescreenCount: 2,
+	}
+	if p.URL != "" {
+		resolved.url = p.URL
+	}
+	if p.ClientTimeoutMS > 0 {
+		resolved.probe.ClientTimeout = time.Duration(p.ClientTimeoutMS) * time.Millisecond
+	}
+	if p.DialTimeoutMS > 0 {
+		resolved.probe.DialTimeout = time.Duration(p.DialTimeoutMS) * time.Millisecond
+	}
+	if resolved.probe.DialTimeout > resolved.probe.ClientTimeout {
+		resolved.probe.DialTimeout = resolved.probe.ClientTimeout // 只设置了较短的 client_timeout_ms
+	}
+	if p.Method != "" {
+		resolved.probe.Method = strings.ToUpper(p.Method)
+	}
+	if p.UserAgent != "" {
+		resolved.probe.UserAgent = p.UserAgent
+	}
+	if len(p.AcceptedStatusCodes) > 0 {
+		resolved.probe.AcceptedStatus = p.AcceptedStatusCodes
+	}
+	if p.MaxLossRate != nil {
+		resolved.maxLossRate = *p.MaxLossRate
+	}
+	if p.PingCount > 0 {
+		resolved.pingCount = p.PingCount
+	}
+	if p.PrescreenCount > 0 {
+		resolved.prescreenCount = p.PrescreenCount
+	}
+	return resolved
+}
+
+// latencyURLFor 返回指定端口使用的延迟测试地址，明文端口优先使用 http_latency_url
+func latencyURLFor(port int, cfg *config.Config) string {
+	if !tester.IsTLSPort(port) && cfg.HTTPLatencyURL != "" {
+		return cfg.HTTPLatencyURL
+	}
+	return tester.URLForPort(resolveLatencyProfile(cfg.LatencyProfile).url, port)
+}
+
+// speedURLFor 返回指定端口使用的下载测速地址，明文端口优先使用 http_speed_url
+func speedURLFor(testURL string, port int, cfg *config.Config) string {
+	if !tester.IsTLSPort(port) && cfg.HTTPSpeedURL != "" {
+		return cfg.HTTPSpeedURL
+	}
+	return tester.URLForPort(testURL, port)
+}
+
+// uploadURLFor 返回指定端口使用的上传测速地址。明文端口只使用 http_upload_url，
+// 默认的上传地址不接受明文 HTTP，未配置时第二个返回值为 false。
+func uploadURLFor(port int, cfg *config.Config) (string, bool) {
+	if !tester.IsTLSPort(port) {
+		return cfg.HTTPUploadURL, cfg.HTTPUploadURL != ""
+	}
+	if cfg.UploadURL != "" {
+		return tester.URLForPort(cfg.UploadURL, port), true
+	}
+	return defaultUploadURL, true
+}
+
+// screenCandidates 对每个候选只做一次 TCP 或 TLS 握手，淘汰不可达与超过 max_latency 的 IP，
+// 并只保留握手最快的 screen_keep_fraction 比例进入完整的延迟测试
+func screenCandidates(ips []model.IPInfo, cfg *config.Config, session *tester.Session, progressCb ProgressCallback) []model.IPInfo {
+	var withTLS bool
+	switch cfg.ScreenMode {
+	case screenModeTCP:
+	case screenModeTLS:
+		withTLS = true
+	default:
+		progressCb(fmt.Sprintf("警告: 未知的 screen_mode '%s'，已跳过预筛。", cfg.ScreenMode))
+		return ips
+	}
+	timeout := time.Duration(cfg.ScreenTimeoutMS) * time.Millisecond
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	fraction := cfg.ScreenKeepFraction
+	if fraction <= 0 || fraction > 1 {
+		fraction = 0.3
+	}
+	concurrency := cfg.LatencyTestConcurrency
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	serverName := ""
+	if u, err := url.Parse(resolveLatencyProfile(cfg.LatencyProfile).url); err == nil {
+		serverName = u.Hostname()
+	}
+	maxDelay := time.Duration(cfg.MaxLatency) * time.Millisecond
+
+	progressCb(fmt.Sprintf("开始对 %d 个 IP 进行 %s 握手预筛...", len(ips), cfg.ScreenMode))
+	type screened struct {
+		ipInfo model.IPInfo
+		delay  time.Duration
+	}
+	var (
+		passed    []screened
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		semaphore = make(chan struct{}, concurrency)
+	)
+	for _, ipInfo := range ips {
+		wg.Add(1)
+		go func(ipInfo model.IPInfo) {
+			semaphore <- struct{}{}
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			// 明文端口没有 TLS，只做 TCP 握手
+			delay, err := session.TestHandshake(&net.IPAddr{IP: ipInfo.Address}, ipInfo.Port, serverName, withTLS && tester.IsTLSPort(ipInfo.Port), timeout)
+			if err != nil || (maxDelay > 0 && delay > maxDelay) {
+				return
+			}
+			mu.Lock()
+			passed = append(passed, screened{ipInfo: ipInfo, delay: delay})
+			mu.Unlock()
+		}(ipInfo)
+	}
+	wg.Wait()
+
+	sort.Slice(passed, func(i, j int) bool { return passed[i].delay < passed[j].delay })
+	keep := int(math.Ceil(float64(len(passed)) * fraction))
+	kept := make([]model.IPInfo, 0, keep)
+	for _, s := range passed[:keep] {
+		kept = append(kept, s.ipInfo)
+	}
+	progressCb(fmt.Sprintf("预筛完成: %d 个 IP 握手成功，保留最快的 %d 个进行完整延迟测试。", len(passed), len(kept)))
+	return kept
+}
+
+// quicPort 判断是否在该端口上进行 HTTP/3 测试。Cloudflare 默认只在 443 上提供 HTTP/3，
+// 其他端口的 QUIC 测试必然失败，因此只测试 quic_ports 中的端口。
+func quicPort(cfg *config.Config, port int) bool {
+	if !cfg.QUICEnabled || !tester.IsTLSPort(port) {
+		return false
+	}
+	if len(cfg.QUICPorts) == 0 {
+		return port == tester.DefaultTCPPort
+	}
+	return slices.Contains(cfg.QUICPorts, port)
+}
+
+// latencySampling 根据配置生成 HTTPing 的自适应采样策略
+func latencySampling(cfg *config.Config) tester.SamplingPolicy {
+	policy := tester.SamplingPolicy{
+		MinSamples: cfg.LatencyMinSamples,
+		MaxSamples: cfg.LatencyMaxSamples,
+		MaxDelay:   time.Duration(cfg.MaxLatency) * time.Millisecond,
+		Precision:  cfg.LatencyCIPrecision,
+	}
+	if policy.MinSamples <= 0 {
+		policy.MinSamples = 3
+	}
+	if policy.MaxSamples <= 0 {
+		policy.MaxSamples = 10
+	}
+	if policy.Precision <= 0 {
+		policy.Precision = 0.1 // 置信区间半宽不超过均值的 10%
+	}
+	return policy
+}
+
+// measureLatency 按 latency_mode 测试单个 IP 的延迟，未通过预筛的 IP 返回的结果会被调用方按阈值淘汰
+func measureLatency(ipInfo model.IPInfo, cfg *config.Config, session *tester.Session) (*tester.HttpingResult, error) {
+	addr := &net.IPAddr{IP: ipInfo.Address}
+	port := ipInfo.Port
+	testURL := latencyURLFor(port, cfg)
+	profile := resolveLatencyProfile(cfg.LatencyProfile)
+	maxDelay := time.Duration(cfg.MaxLatency) * time.Millisecond
+
+	switch cfg.LatencyMode {
+	case latencyModeTCPing:
+		res, err := session.TestTCPLatency(addr, port, profile.pingCount, profile.probe.DialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if res.LossRate > profile.maxLossRate || res.Delay > maxDelay {
+			return res, nil // 不会被保留，无需再获取 Colo
+		}
+		// 获取 Colo 失败时仍保留该 IP，区域记为 Unknown
+		if colo, trace, err := session.DetectColo(addr, port, testURL, profile.probe); err == nil {
+			res.Colo, res.Trace = colo, trace
+		}
+		return res, nil
+	case latencyModeHybrid:
+		pre, err := session.TestTCPLatency(addr, port, profile.prescreenCount, profile.probe.DialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if pre.LossRate > profile.maxLossRate || pre.Delay > maxDelay {
+			return pre, nil
+		}
+		return session.TestLatencyAdaptive(addr, port, testURL, latencySampling(cfg), profile.probe)
+	default:
+		return session.TestLatencyAdaptive(addr, port, testURL, latencySampling(cfg), profile.probe)
+	}
+}
+
+func testLatencies(ips []model.IPInfo, cfg *config.Config, session *tester.Session, regionMap locations.RegionMap, quarantineList *quarantine.List, progressCb ProgressCallback) []model.LatencyResult {
 	var (
 		latencyResults []model.LatencyResult
 		wg             sync.WaitGroup
@@ -191,12 +884,20 @@ func testLatencies(ips []model.IPInfo, cfg *config.Config, regionMap locations.R
 	)
 	progressCb(fmt.Sprintf("开始对 %d 个 Cloudflare IP 进行并发延迟测试...", len(ips)))
 
+	switch cfg.LatencyMode {
+	case "", latencyModeHTTPing, latencyModeTCPing, latencyModeHybrid:
+	default:
+		log.Printf("警告: 未知的 latency_mode '%s'，自动调整为 %s。", cfg.LatencyMode, latencyModeHTTPing)
+		cfg.LatencyMode = latencyModeHTTPing
+	}
+
 	// 增加对 LatencyTestConcurrency 的检查
 	if cfg.LatencyTestConcurrency <= 0 {
 		log.Printf("警告: LatencyTestConcurrency 被设置为 %d，可能导致死锁。自动调整为默认值 10。", cfg.LatencyTestConcurrency)
 		cfg.LatencyTestConcurrency = 10
 	}
 	latencySemaphore := make(chan struct{}, cfg.LatencyTestConcurrency)
+	profile := resolveLatencyProfile(cfg.LatencyProfile)
 
 	for _, ipInfo := range ips {
 		wg.Add(1)
@@ -207,13 +908,21 @@ func testLatencies(ips []model.IPInfo, cfg *config.Config, regionMap locations.R
 				wg.Done()
 			}()
 
-			res, err := tester.TestLatency(&net.IPAddr{IP: ipInfo.Address}, "https://www.cloudflare.com/cdn-cgi/trace", 4)
+			res, err := measureLatency(ipInfo, cfg, session)
 			if err != nil {
 				// log.Printf("IP %s 延迟测试失败: %v", ipInfo.Address, err)
+				if quarantineList.Strike(ipInfo.Address, ipInfo.Port, classifyFailure(err)) {
+					progressCb(fmt.Sprintf("IP %s 多次延迟测试失败，已加入隔离列表: %v", ipInfo.Endpoint(), err))
+				}
 				return
 			}
+			// 延迟测试成功说明 IP 可以连通，清除超时与状态码失败；低速失败只在测速成功时清除
+			quarantineList.Clear(ipInfo.Address, ipInfo.Port, quarantine.KindTimeout, quarantine.KindStatus)
 
-			if res.LossRate > 0.1 || res.Delay > time.Duration(cfg.MaxLatency)*time.Millisecond {
+			if res.LossRate > profile.maxLossRate || res.Delay > time.Duration(cfg.MaxLatency)*time.Millisecond {
+				return
+			}
+			if cfg.MaxJitter > 0 && res.Stats.Jitter > time.Duration(cfg.MaxJitter)*time.Millisecond {
 				return
 			}
 
@@ -223,17 +932,30 @@ func testLatencies(ips []model.IPInfo, cfg *config.Config, regionMap locations.R
 			}
 
 			result := model.LatencyResult{
-				IPInfo:   ipInfo,
-				Delay:    res.Delay,
-				LossRate: res.LossRate,
-				Colo:     res.Colo,
-				Region:   region,
+				IPInfo:       ipInfo,
+				LatencyStats: res.Stats,
+				Delay:        res.Delay,
+				LossRate:     res.LossRate,
+				Colo:         res.Colo,
+				Region:       region,
+				Trace:        res.Trace,
+				TCPInfo:      res.TCPInfo,
+			}
+
+			if quicPort(cfg, ipInfo.Port) {
+				quicRes, err := session.TestLatencyHTTP3(&net.IPAddr{IP: ipInfo.Address}, ipInfo.Port, tester.URLForPort(profile.url, ipInfo.Port), latencySampling(cfg), profile.probe)
+				if err == nil {
+					result.QUICDelay = quicRes.Delay
+					result.QUICLossRate = quicRes.LossRate
+				} else {
+					result.QUICLossRate = 1
+				}
 			}
 
 			mu.Lock()
 			latencyResults = append(latencyResults, result)
 			mu.Unlock()
-			progressCb(fmt.Sprintf("IP %s: 延迟=%.2fms, 丢包=%.0f%%, Colo=%s, 区域=%s", ipInfo.Address, float64(res.Delay.Milliseconds()), res.LossRate*100, res.Colo, region))
+			progressCb(fmt.Sprintf("IP %s: 延迟=%.2fms, 抖动=%.2fms, 丢包=%.0f%%, Colo=%s, 区域=%s", ipInfo.Endpoint(), float64(res.Delay.Milliseconds()), float64(res.Stats.Jitter.Microseconds())/1000, res.LossRate*100, res.Colo, region))
 		}(ipInfo)
 	}
 	wg.Wait()
@@ -272,13 +994,22 @@ func filterResults(results []model.LatencyResult, cfg *config.Config) []model.La
 	return filtered
 }
 
-func groupResults(results []model.LatencyResult, groupBy string) map[string][]model.LatencyResult {
+func groupResults(results []model.LatencyResult, groupBy, rankBy string) map[string][]model.LatencyResult {
 	grouped := make(map[string][]model.LatencyResult)
 	for _, res := range results {
 		var key string
 		switch groupBy {
 		case "colo":
 			key = res.Colo
+		case "prefix":
+			key = res.Prefix
+			if key == "" {
+				key = "Unknown"
+			}
+		case "asn":
+			key = fmt.Sprintf("AS%d", res.OriginASN)
+		case "port":
+			key = strconv.Itoa(res.Port)
 		case "region":
 			fallthrough
 		default:
@@ -290,102 +1021,568 @@ func groupResults(results []model.LatencyResult, groupBy string) map[string][]mo
 	// 对每个分组按延迟排序
 	for key := range grouped {
 		sort.Slice(grouped[key], func(i, j int) bool {
+			if rankBy == rankByQUIC {
+				// HTTP/3 不通的 IP 排在最后
+				qi, qj := grouped[key][i].QUICDelay, grouped[key][j].QUICDelay
+				if (qi == 0) != (qj == 0) {
+					return qj == 0
+				}
+				if qi != qj {
+					return qi < qj
+				}
+			}
+			if rankBy == rankByLoss {
+				li, lj := grouped[key][i].TCPInfo.LossRatio(), grouped[key][j].TCPInfo.LossRatio()
+				if li != lj {
+					return li < lj
+				}
+			}
 			return grouped[key][i].Delay < grouped[key][j].Delay
 		})
 	}
 	return grouped
 }
 
-func testSpeedsWithRetry(groupedResults map[string][]model.LatencyResult, cfg *config.Config, progressCb ProgressCallback) []SimplifiedResult {
+// speedMeasurement 汇总一个 IP 的各项速度测试结果
+type speedMeasurement struct {
+	tcp    *tester.SpeedTestResult     // 失败时为 nil
+	quic   *tester.SpeedTestResult     // 未启用 HTTP/3 或失败时为 nil
+	upload *tester.UploadTestResult    // 未启用上传测速或失败时为 nil
+	loaded *tester.LoadedLatencyResult // 未启用负载延迟测量或探测失败时为 nil
+	tcpErr error                       // 下载测速的错误，用于统计测速地址
+}
+
+// rankSpeed 返回用于最低速度判断与排序的速度（B/s）
+func (m *speedMeasurement) rankSpeed(cfg *config.Config) float64 {
+	switch cfg.RankBy {
+	case rankByQUIC:
+		return speedOf(m.quic)
+	case rankByUpload:
+		return m.uploadSpeed()
+	}
+	return speedOf(m.tcp)
+}
+
+// uploadSpeed 返回上传速度（B/s），未测试或失败时返回 0
+func (m *speedMeasurement) uploadSpeed() float64 {
+	if m.upload == nil {
+		return 0
+	}
+	return m.upload.UploadSpeed
+}
+
+// speedOf 返回测速结果中的下载速度（B/s），结果为 nil 时返回 0
+func speedOf(res *tester.SpeedTestResult) float64 {
+	if res == nil {
+		return 0
+	}
+	return res.DownloadSpeed
+}
+
+// formatStreamSpeeds 将多连接测速中各连接的速度格式化为日志后缀，单连接时返回空字符串
+func formatStreamSpeeds(speeds []int) string {
+	if len(speeds) < 2 {
+		return ""
+	}
+	parts := make([]string, len(speeds))
+	for i, s := range speeds {
+		parts[i] = fmt.Sprintf("%.2f", float64(s)/1024.0)
+	}
+	return fmt.Sprintf(" (%d 连接: %s)", len(speeds), strings.Join(parts, " / "))
+}
+
+// formatQUICSpeed 在该端口进行了 HTTP/3 测试时返回 HTTP/3 下载速度的日志片段
+func formatQUICSpeed(cfg *config.Config, port int, quicSpeed int) string {
+	if !quicPort(cfg, port) {
+		return ""
+	}
+	return fmt.Sprintf(", HTTP/3 下载速度=%.2f MB/s", float64(quicSpeed)/1024.0)
+}
+
+// formatUploadSpeed 在启用上传测速时返回上传速度的日志片段
+func formatUploadSpeed(cfg *config.Config, uploadSpeed int) string {
+	if !cfg.UploadEnabled {
+		return ""
+	}
+	return fmt.Sprintf(", 上传速度=%.2f MB/s", float64(uploadSpeed)/1024.0)
+}
+
+// bufferbloatAcceptable 判断负载延迟的等级是否不差于 maxGrade，未测得负载延迟时视为不达标
+func bufferbloatAcceptable(loaded *tester.LoadedLatencyResult, maxGrade string) bool {
+	if loaded == nil {
+		return false
+	}
+	limit, _ := tester.BufferbloatGradeRank(maxGrade)
+	rank, _ := tester.BufferbloatGradeRank(loaded.Grade())
+	return rank <= limit
+}
+
+// speedTestDuration 根据配置生成下载测速的时长策略，默认最短 3 秒、最长 10 秒，未设置容差时测满最长时长
+func speedTestDuration(cfg *config.Config) tester.DurationPolicy {
+	policy := tester.DurationPolicy{
+		MinDuration: 3 * time.Second,
+		MaxDuration: 10 * time.Second,
+		Tolerance:   cfg.SpeedTestTolerance,
+	}
+	if cfg.SpeedTestMinDuration > 0 {
+		policy.MinDuration = time.Duration(cfg.SpeedTestMinDuration) * time.Millisecond
+	}
+	if cfg.SpeedTestMaxDuration > 0 {
+		policy.MaxDuration = time.Duration(cfg.SpeedTestMaxDuration) * time.Millisecond
+	}
+	policy.MinDuration = min(policy.MinDuration, policy.MaxDuration)
+	if shapingThrottleRatio(cfg) > 0 {
+		// 提前结束会截断吞吐量曲线，突发后的限速还没出现就停止测速，检测突发后限速时始终测满最长时长
+		policy.Tolerance = 0
+	}
+	return policy
+}
+
+// shapingThrottleRatio 返回判定突发后限速的持续/突发速度比例。
+// 启用测速限速时令牌桶允许的初始突发会被误判为限速，因此不做该判断。
+func shapingThrottleRatio(cfg *config.Config) float64 {
+	if cfg.SpeedTestRateLimitMB > 0 || cfg.ShapingThrottleRatio < 0 {
+		return 0
+	}
+	if cfg.ShapingThrottleRatio == 0 {
+		return 0.5
+	}
+	return cfg.ShapingThrottleRatio
+}
+
+// formatLoadedLatency 在测得负载延迟时返回其日志片段
+func formatLoadedLatency(loaded *tester.LoadedLatencyResult) string {
+	if loaded == nil {
+		return ""
+	}
+	return fmt.Sprintf(", 负载延迟=%dms (+%dms, %s)", loaded.LoadedDelay.Milliseconds(), loaded.Increase().Milliseconds(), loaded.Grade())
+}
+
+// measureSpeed 对单个 IP 进行速度测试，返回的错误只反映排序依据所用协议的测试结果
+func measureSpeed(ipInfo model.IPInfo, testURL string, cfg *config.Config, session *tester.Session, progressCb ProgressCallback) (*speedMeasurement, error) {
+	addr := &net.IPAddr{IP: ipInfo.Address}
+	m := &speedMeasurement{}
+
+	streams := max(cfg.SpeedTestStreams, 1)
+	duration := speedTestDuration(cfg)
 	var (
-		finalResults     []SimplifiedResult
-		wg               sync.WaitGroup
-		mu               sync.Mutex
-		discardedCounter int32 // 使用原子操作来安全地计数
+		tcpRes *tester.SpeedTestResult
+		tcpErr error
 	)
+	downloadTCP := func() {
+		tcpRes, tcpErr = session.TestDownloadSpeedStreams(addr, ipInfo.Port, speedURLFor(testURL, ipInfo.Port, cfg), duration, cfg.SpeedTestRateLimitMB, streams)
+	}
+	if cfg.LoadedLatencyEnabled {
+		interval := 200 * time.Millisecond
+		if cfg.LoadedLatencyInterval > 0 {
+			interval = time.Duration(cfg.LoadedLatencyInterval) * time.Millisecond
+		}
+		loaded, err := session.TestLatencyUnderLoad(addr, ipInfo.Port, interval, downloadTCP)
+		if err != nil {
+			progressCb(fmt.Sprintf("IP %s 负载延迟测量失败: %v", ipInfo.Endpoint(), err))
+		}
+		m.loaded = loaded
+	} else {
+		downloadTCP()
+	}
+	m.tcp, m.tcpErr = tcpRes, tcpErr
 
-	const (
-		primaryTestURL   = "https://speed.cloudflare.com/__down?bytes=200000000"
-		secondaryTestURL = "https://cf.xiu2.xyz/url"
-	)
-	currentTestURL := primaryTestURL
+	var uploadErr error
+	if cfg.UploadEnabled {
+		if uploadURL, ok := uploadURLFor(ipInfo.Port, cfg); ok {
+			m.upload, uploadErr = session.TestUploadSpeed(addr, ipInfo.Port, uploadURL, duration.MaxDuration, cfg.SpeedTestRateLimitMB)
+		} else if cfg.RankBy == rankByUpload {
+			uploadErr = errNoHTTPUploadURL
+		}
+	}
 
-	speedTestSemaphore := make(chan struct{}, cfg.SpeedTestConcurrency)
+	var quicErr error
+	if quicPort(cfg, ipInfo.Port) {
+		m.quic, quicErr = session.TestDownloadSpeedHTTP3(addr, ipInfo.Port, testURL, duration, cfg.SpeedTestRateLimitMB, streams)
+	}
 
-	for groupName, candidates := range groupedResults {
-		wg.Add(1)
-		go func(groupName string, candidates []model.LatencyResult) {
-			defer wg.Done()
-			var successfulTests []SimplifiedResult
+	// 排序依据所用的测试失败时整个测速视为失败，其余测试失败只记录日志。失败时仍返回 m，供调用方统计测速地址
+	switch cfg.RankBy {
+	case rankByQUIC:
+		if quicErr != nil {
+			return m, quicErr
+		}
+	case rankByUpload:
+		if uploadErr != nil {
+			return m, uploadErr
+		}
+	default:
+		if tcpErr != nil {
+			return m, tcpErr
+		}
+	}
+	if tcpErr != nil && (cfg.RankBy == rankByQUIC || cfg.RankBy == rankByUpload) {
+		progressCb(fmt.Sprintf("IP %s 下载速度测试失败: %v", ipInfo.Endpoint(), tcpErr))
+	}
+	if uploadErr != nil && cfg.RankBy != rankByUpload {
+		progressCb(fmt.Sprintf("IP %s 上传速度测试失败: %v", ipInfo.Endpoint(), uploadErr))
+	}
+	if quicErr != nil && cfg.RankBy != rankByQUIC {
+		progressCb(fmt.Sprintf("IP %s HTTP/3 速度测试失败: %v", ipInfo.Endpoint(), quicErr))
+	}
+	return m, nil
+}
 
-			progressCb(fmt.Sprintf("开始测试分组 '%s'，目标 %d 个，候选 %d 个...", groupName, cfg.TopNPerGroup, len(candidates)))
+// newSpeedURLChain 根据配置创建测速地址链，启用健康检查时先逐个检查各地址
+func newSpeedURLChain(cfg *config.Config, session *tester.Session, progressCb ProgressCallback) *speedurl.Chain {
+	urls := cfg.SpeedURLs
+	if len(urls) == 0 {
+		urls = []string{defaultSpeedURL, fallbackSpeedURL}
+	}
+	maxFailures := cfg.SpeedURLMaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 5
+	}
+	port := tester.DefaultTCPPort
+	if cfg.PlainHTTP {
+		port = tester.DefaultHTTPPort
+	}
+	chain := speedurl.New(urls, maxFailures, func(testURL string) error {
+		return session.CheckSpeedURL(speedURLFor(testURL, port, cfg), 5*time.Second)
+	})
+	if !cfg.SpeedURLHealthCheck {
+		return chain
+	}
 
-			for _, candidate := range candidates {
-				// 如果已经收集到足够的结果，则停止该分组的测试
-				if len(successfulTests) >= cfg.TopNPerGroup {
-					break
-				}
+	failures := chain.CheckHealth()
+	for _, testURL := range urls {
+		if err, failed := failures[testURL]; failed {
+			progressCb(fmt.Sprintf("警告: 测速地址 %s 健康检查失败，已标记为不可用: %v", testURL, err))
+		}
+	}
+	if _, ok := chain.Current(); !ok {
+		progressCb("警告: 所有测速地址的健康检查均失败，将仍然使用第一个地址进行测速。")
+	}
+	return chain
+}
 
-				// 检查是否需要切换URL
-				if atomic.LoadInt32(&discardedCounter) >= 10 {
-					mu.Lock()
-					if currentTestURL == primaryTestURL {
-						currentTestURL = secondaryTestURL
-						progressCb(fmt.Sprintf("警告: 已连续舍弃 %d 个低速IP，自动切换到备用测速地址: %s", atomic.LoadInt32(&discardedCounter), secondaryTestURL))
-					}
-					mu.Unlock()
-				}
+// isSpeedURLFailure 判断测速错误是否由测速地址本身引起（非预期状态码或被重定向到 HTTPS）
+func isSpeedURLFailure(err error) bool {
+	return errors.Is(err, tester.ErrInvalidStatus) || errors.Is(err, tester.ErrHTTPSRedirect)
+}
 
-				mu.Lock()
-				urlToTest := currentTestURL
-				mu.Unlock()
+// measureSpeedWithFailover 使用当前健康的测速地址测速，地址本身出错时依次换用后续地址重试同一个 IP，
+// 并记录各地址的统计。返回实际请求的测速地址（明文端口为 http_speed_url 或改为 http:// 的地址）。
+// 所有地址都因自身问题失败时返回 errSpeedURLUnavailable，这与 IP 无关。
+// 上传测速使用 upload_url，不在测速地址链中：按上传速度排序时仍统计同时进行的下载，但不因下载失败切换地址重测。
+func measureSpeedWithFailover(ipInfo model.IPInfo, cfg *config.Config, session *tester.Session, speedURLs *speedurl.Chain, progressCb ProgressCallback) (*speedMeasurement, string, error) {
+	testURL, ok := speedURLs.Current()
+	if !ok {
+		for _, restored := range speedURLs.Recheck() {
+			progressCb(fmt.Sprintf("测速地址 %s 重新检查通过，已恢复使用。", restored))
+		}
+		testURL, _ = speedURLs.Current()
+	}
+	for {
+		measurement, err := measureSpeed(ipInfo, testURL, cfg, session, progressCb)
+		effectiveURL := speedURLFor(testURL, ipInfo.Port, cfg)
 
-				speedTestSemaphore <- struct{}{}
+		// 测速地址的成败取决于使用它的测试：HTTP/3 排序时为 HTTP/3 下载，其余为 TCP 下载
+		urlErr, speed := measurement.tcpErr, speedOf(measurement.tcp)
+		if cfg.RankBy == rankByQUIC {
+			urlErr, speed = err, speedOf(measurement.quic)
+		}
+		if urlErr == nil {
+			speedURLs.RecordSuccess(testURL, speed)
+		} else if speedURLs.RecordFailure(testURL, isSpeedURLFailure(urlErr)) {
+			progressCb(fmt.Sprintf("警告: 测速地址 %s 连续返回错误状态码且重新检查失败，已标记为不可用。", testURL))
+		}
+		if err == nil {
+			return measurement, effectiveURL, nil
+		}
+		if isSpeedURLFailure(err) && cfg.RankBy == rankByUpload {
+			// upload_url 本身出错，与 IP 无关；上传测速不在测速地址链中，不切换地址重测
+			return nil, effectiveURL, fmt.Errorf("%w: %w", errSpeedURLUnavailable, err)
+		}
+		if !isSpeedURLFailure(err) || cfg.RankBy == rankByUpload {
+			return nil, effectiveURL, err
+		}
+		next, ok := speedURLs.Next(testURL)
+		if !ok {
+			return nil, effectiveURL, fmt.Errorf("%w: %w", errSpeedURLUnavailable, err)
+		}
+		progressCb(fmt.Sprintf("IP %s 使用测速地址 %s 失败 (%v)，改用 %s 重试。", ipInfo.Endpoint(), effectiveURL, err, next))
+		testURL = next
+	}
+}
 
-				speedRes, err := tester.TestDownloadSpeed(&net.IPAddr{IP: candidate.IPInfo.Address}, urlToTest, 10*time.Second, cfg.SpeedTestRateLimitMB)
+// reportSpeedURLStats 输出各测速地址的使用统计
+func reportSpeedURLStats(speedURLs *speedurl.Chain, progressCb ProgressCallback) {
+	for _, s := range speedURLs.Stats() {
+		if s.Attempts == 0 && s.Healthy {
+			continue
+		}
+		state := "可用"
+		if !s.Healthy {
+			state = "不可用"
+		}
+		progressCb(fmt.Sprintf("测速地址 %s (%s): 测速 %d 次，成功 %d 次，状态码错误 %d 次，其他错误 %d 次，平均速度 %.2f MB/s",
+			s.URL, state, s.Attempts, s.Successes, s.StatusFailures, s.Errors, s.AverageSpeed()/1024/1024))
+	}
+}
 
-				<-speedTestSemaphore
+// usageStageNames 是流量统计中各阶段在日志中的名称
+var usageStageNames = map[string]string{
+	usage.StageDNS:     "DNS 解析",
+	usage.StageLatency: "延迟测试",
+	usage.StageSpeed:   "速度测试",
+}
 
-				if err != nil {
-					progressCb(fmt.Sprintf("IP %s 速度测试失败: %v", candidate.IPInfo.Address, err))
-					continue // 失败，继续下一个候选
-				}
+// reportStageUsage 输出单个阶段的流量
+func reportStageUsage(meter *usage.Meter, stage string, progressCb ProgressCallback) {
+	if meter == nil {
+		return
+	}
+	c := meter.Stage(stage)
+	progressCb(fmt.Sprintf("%s阶段流量: 发送 %s，接收 %s", usageStageNames[stage], formatBytes(c.Sent), formatBytes(c.Received)))
+}
 
-				// 检查速度是否低于最低要求
-				speedInMBps := speedRes.DownloadSpeed / 1024 / 1024
-				if cfg.MinSpeed > 0 && speedInMBps < cfg.MinSpeed {
-					progressCb(fmt.Sprintf("IP %s 速度 %.2f MB/s 低于最低要求 %.2f MB/s, 已舍弃", candidate.IPInfo.Address, speedInMBps, cfg.MinSpeed))
-					mu.Lock()
-					isPrimaryURL := currentTestURL == primaryTestURL
-					mu.Unlock()
-					if isPrimaryURL {
-						atomic.AddInt32(&discardedCounter, 1)
-					}
-					continue // 速度不达标，继续下一个候选
-				}
+// topUsageIPs 是运行结束时在日志中列出的流量最多的 IP 数
+const topUsageIPs = 10
 
-				result := SimplifiedResult{
-					Address:       candidate.IPInfo.Address.String(),
-					SourceDomain:  candidate.IPInfo.SourceDomain,
-					Delay:         candidate.Delay.Nanoseconds(),
-					LossRate:      candidate.LossRate,
-					Colo:          candidate.Colo,
-					Region:        candidate.Region,
-					DownloadSpeed: int(speedRes.DownloadSpeed / 1024), // B/s to KB/s, then to int
-				}
+// reportUsage 在运行结束时输出流量汇总：总量、各阶段流量以及流量最多的 IP
+func reportUsage(report usage.Report, progressCb ProgressCallback) {
+	progressCb(fmt.Sprintf("本次运行总流量 %s（发送 %s，接收 %s），涉及 %d 个 IP。",
+		formatBytes(report.Total.Total()), formatBytes(report.Total.Sent), formatBytes(report.Total.Received), len(report.IPs)))
+	for _, s := range report.Stages {
+		progressCb(fmt.Sprintf("  %s: %s", usageStageNames[s.Stage], formatBytes(s.Total())))
+	}
+	for i, ip := range report.IPs {
+		if i == topUsageIPs {
+			progressCb(fmt.Sprintf("  ……其余 %d 个 IP 略", len(report.IPs)-topUsageIPs))
+			break
+		}
+		progressCb(fmt.Sprintf("  %s: %s（发送 %s，接收 %s）", ip.IP, formatBytes(ip.Total()), formatBytes(ip.Sent), formatBytes(ip.Received)))
+	}
+}
 
-				mu.Lock()
-				successfulTests = append(successfulTests, result)
-				finalResults = append(finalResults, result)
-				mu.Unlock()
+// formatBytes 将字节数格式化为便于阅读的单位
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1024*1024*1024:
+		return fmt.Sprintf("%.2f GB", float64(n)/1024/1024/1024)
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.2f MB", float64(n)/1024/1024)
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
 
-				progressCb(fmt.Sprintf("IP %s: 下载速度=%.2f MB/s (分组: %s)", candidate.IPInfo.Address, float64(result.DownloadSpeed)/1024.0, groupName))
+// evaluateSpeed 根据一次测速的结果更新 IP 池与隔离列表，并按各项最低要求筛选，未通过时返回 nil
+func evaluateSpeed(candidate model.LatencyResult, groupName string, measurement *speedMeasurement, urlToTest string, err error, cfg *config.Config, ipPool *pool.Pool, quarantineList *quarantine.List, progressCb ProgressCallback) *SimplifiedResult {
+	if errors.Is(err, errSpeedURLUnavailable) {
+		progressCb(fmt.Sprintf("IP %s 速度测试失败: %v（测速地址的问题，不计入该 IP 的失败）", candidate.IPInfo.Endpoint(), err))
+		return nil
+	}
+	if err != nil {
+		progressCb(fmt.Sprintf("IP %s 速度测试失败: %v", candidate.IPInfo.Endpoint(), err))
+		ipPool.RecordFailure(candidate.IPInfo.Address)
+		if quarantineList.Strike(candidate.IPInfo.Address, candidate.IPInfo.Port, classifyFailure(err)) {
+			progressCb(fmt.Sprintf("IP %s 多次速度测试失败，已加入隔离列表。", candidate.IPInfo.Endpoint()))
+		}
+		return nil
+	}
+
+	// 检查速度是否低于最低要求
+	speedInMBps := measurement.rankSpeed(cfg) / 1024 / 1024
+	if speedInMBps < cfg.QuarantineLowSpeedMB {
+		if quarantineList.Strike(candidate.IPInfo.Address, candidate.IPInfo.Port, quarantine.KindLowSpeed) {
+			progressCb(fmt.Sprintf("IP %s 速度多次接近于零，已加入隔离列表。", candidate.IPInfo.Endpoint()))
+		}
+	} else {
+		quarantineList.Clear(candidate.IPInfo.Address, candidate.IPInfo.Port)
+	}
+	if cfg.MinSpeed > 0 && speedInMBps < cfg.MinSpeed {
+		progressCb(fmt.Sprintf("IP %s 速度 %.2f MB/s 低于最低要求 %.2f MB/s, 已舍弃", candidate.IPInfo.Endpoint(), speedInMBps, cfg.MinSpeed))
+		ipPool.RecordFailure(candidate.IPInfo.Address)
+		return nil
+	}
+	if uploadMBps := measurement.uploadSpeed() / 1024 / 1024; cfg.UploadEnabled && cfg.MinUploadSpeed > 0 && uploadMBps < cfg.MinUploadSpeed {
+		progressCb(fmt.Sprintf("IP %s 上传速度 %.2f MB/s 低于最低要求 %.2f MB/s, 已舍弃", candidate.IPInfo.Endpoint(), uploadMBps, cfg.MinUploadSpeed))
+		ipPool.RecordFailure(candidate.IPInfo.Address)
+		return nil
+	}
+	if cfg.MaxBufferbloatGrade != "" && !bufferbloatAcceptable(measurement.loaded, cfg.MaxBufferbloatGrade) {
+		progressCb(fmt.Sprintf("IP %s 缓冲膨胀等级未达到 %s%s, 已舍弃", candidate.IPInfo.Endpoint(), cfg.MaxBufferbloatGrade, formatLoadedLatency(measurement.loaded)))
+		ipPool.RecordFailure(candidate.IPInfo.Address)
+		return nil
+	}
+
+	result := SimplifiedResult{
+		Address:           candidate.IPInfo.Address.String(),
+		Port:              candidate.IPInfo.Port,
+		SourceDomain:      candidate.IPInfo.SourceDomain,
+		Delay:             candidate.Delay.Nanoseconds(),
+		DelayCILow:        candidate.DelayCILow.Nanoseconds(),
+		DelayCIHigh:       candidate.DelayCIHigh.Nanoseconds(),
+		Samples:           candidate.Samples,
+		MinDelay:          candidate.MinDelay.Nanoseconds(),
+		MedianDelay:       candidate.MedianDelay.Nanoseconds(),
+		P90Delay:          candidate.P90Delay.Nanoseconds(),
+		MaxDelay:          candidate.MaxDelay.Nanoseconds(),
+		Jitter:            candidate.Jitter.Nanoseconds(),
+		ConnectTime:       candidate.ConnectTime.Nanoseconds(),
+		TLSTime:           candidate.TLSTime.Nanoseconds(),
+		TTFB:              candidate.TTFB.Nanoseconds(),
+		LossRate:          candidate.LossRate,
+		Colo:              candidate.Colo,
+		Region:            candidate.Region,
+		ClientIP:          candidate.Trace.ClientIP,
+		Country:           candidate.Trace.Country,
+		HTTPVersion:       candidate.Trace.HTTPVersion,
+		TLSVersion:        candidate.Trace.TLSVersion,
+		Prefix:            candidate.IPInfo.Prefix,
+		OriginASN:         candidate.IPInfo.OriginASN,
+		DownloadSpeed:     int(speedOf(measurement.tcp) / 1024), // B/s to KB/s, then to int
+		UploadSpeed:       int(measurement.uploadSpeed() / 1024),
+		QUICDelay:         candidate.QUICDelay.Nanoseconds(),
+		QUICLossRate:      candidate.QUICLossRate,
+		QUICDownloadSpeed: int(speedOf(measurement.quic) / 1024),
+		SpeedURL:          urlToTest,
+	}
+	if measurement.loaded != nil {
+		result.LoadedDelay = measurement.loaded.LoadedDelay.Nanoseconds()
+		result.LatencyIncrease = measurement.loaded.Increase().Nanoseconds()
+		result.BufferbloatGrade = measurement.loaded.Grade()
+	}
+	if measurement.tcp != nil {
+		for _, s := range measurement.tcp.StreamSpeeds {
+			result.StreamSpeeds = append(result.StreamSpeeds, int(s/1024))
+		}
+		for _, s := range measurement.tcp.Throughput {
+			result.Throughput = append(result.Throughput, int(s/1024))
+		}
+		shaping := tester.AnalyzeThroughput(measurement.tcp.Throughput, shapingThrottleRatio(cfg))
+		result.BurstSpeed = int(shaping.BurstSpeed / 1024)
+		result.SustainedSpeed = int(shaping.SustainedSpeed / 1024)
+		result.Stalls = shaping.Stalls
+		result.ShapingPattern = shaping.Pattern
+		info := measurement.tcp.TCPInfo
+		result.KernelRTT = info.RTT.Nanoseconds()
+		result.KernelRTTVar = info.RTTVar.Nanoseconds()
+		result.Retransmits = info.Retransmits
+		result.LostSegments = info.Lost
+		result.OutOfOrder = info.OutOfOrder
+		result.DeliveryRate = int(info.DeliveryRate / 1024)
+		result.TCPLossRatio = info.LossRatio()
+	}
+	if measurement.upload != nil {
+		// 上传方向的重传与丢包只在上传连接的内核统计中可见
+		result.UploadRetransmits = measurement.upload.TCPInfo.Retransmits
+		result.UploadLossRatio = measurement.upload.TCPInfo.LossRatio()
+	}
+
+	ipPool.RecordSuccess(candidate.IPInfo.Address, candidate.Delay, speedInMBps, candidate.Colo)
+
+	if result.ShapingPattern != "" {
+		progressCb(fmt.Sprintf("警告: IP %s 疑似被限速 (%s): 突发速度=%.2f MB/s, 持续速度=%.2f MB/s, 停顿 %d 次", candidate.IPInfo.Endpoint(), result.ShapingPattern, float64(result.BurstSpeed)/1024.0, float64(result.SustainedSpeed)/1024.0, result.Stalls))
+	}
+
+	progressCb(fmt.Sprintf("IP %s: 下载速度=%.2f MB/s%s%s%s%s (分组: %s)", candidate.IPInfo.Endpoint(), float64(result.DownloadSpeed)/1024.0, formatStreamSpeeds(result.StreamSpeeds), formatQUICSpeed(cfg, candidate.IPInfo.Port, result.QUICDownloadSpeed), formatUploadSpeed(cfg, result.UploadSpeed), formatLoadedLatency(measurement.loaded), groupName))
+	return &result
+}
+
+// speedTestContentionRatio 返回判定并发测速互相影响的速度比例：并发进行的测速低于基准速度的该比例时单独重测
+func speedTestContentionRatio(cfg *config.Config) float64 {
+	if cfg.SpeedTestContention < 0 {
+		return 0
+	}
+	if cfg.SpeedTestContention == 0 {
+		return 0.5
+	}
+	return cfg.SpeedTestContention
+}
+
+// testSpeedsWithRetry 由调度器在各分组之间轮流分配测速任务，直到每个分组获得 top_n_per_group 个结果或候选耗尽。
+// 并发测速时先为每个分组单独测出一个基准速度；与其他测速同时进行、且明显低于所在分组基准的测速视为互相抢占带宽，
+// 推迟到并发测速结束后单独重测。分组内的 IP 通常位于同一数据中心或地区，速度的可比性远高于跨分组比较。
+func testSpeedsWithRetry(groupedResults map[string][]model.LatencyResult, cfg *config.Config, session *tester.Session, speedURLs *speedurl.Chain, ipPool *pool.Pool, quarantineList *quarantine.List, progressCb ProgressCallback) []SimplifiedResult {
+	sched := newSpeedScheduler(groupedResults, cfg.TopNPerGroup)
+	for _, g := range sched.groups {
+		progressCb(fmt.Sprintf("开始测试分组 '%s'，目标 %d 个，候选 %d 个...", g.name, cfg.TopNPerGroup, len(g.candidates)))
+	}
+
+	runJob := func(job *speedJob) (*speedMeasurement, string, error) {
+		return measureSpeedWithFailover(job.candidate.IPInfo, cfg, session, speedURLs, progressCb)
+	}
+	finishJob := func(job *speedJob, measurement *speedMeasurement, urlToTest string, err error) {
+		sched.complete(job, evaluateSpeed(job.candidate, job.group.name, measurement, urlToTest, err, cfg, ipPool, quarantineList, progressCb))
+	}
+
+	contentionRatio := speedTestContentionRatio(cfg)
+	if cfg.SpeedTestConcurrency > 1 && contentionRatio > 0 {
+		// 没有其他测速干扰时为每个分组测出基准速度，每个分组最多尝试 3 个候选
+		for _, g := range sched.groups {
+			for attempt := 0; attempt < 3 && g.baseline == 0; attempt++ {
+				job, ok := sched.takeFrom(g)
+				if !ok {
+					break
+				}
+				measurement, urlToTest, err := runJob(job)
+				sched.stop(job)
+				if err == nil {
+					g.baseline = measurement.rankSpeed(cfg)
+				}
+				finishJob(job, measurement, urlToTest, err)
 			}
-			progressCb(fmt.Sprintf("分组 '%s' 测试完成，成功获取 %d 个结果。", groupName, len(successfulTests)))
+			if g.baseline > 0 {
+				progressCb(fmt.Sprintf("分组 '%s' 基准速度: %.2f MB/s（单独测速）", g.name, g.baseline/1024/1024))
+			}
+		}
+	}
 
-		}(groupName, candidates)
+	for {
+		var wg sync.WaitGroup
+		for i := 0; i < max(cfg.SpeedTestConcurrency, 1); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					job, ok := sched.take()
+					if !ok {
+						return
+					}
+					measurement, urlToTest, err := runJob(job)
+					overlapped := sched.stop(job)
+					baseline := job.group.baseline
+					if err == nil && overlapped && contentionRatio > 0 && measurement.rankSpeed(cfg) < baseline*contentionRatio {
+						job.contended = measurement.rankSpeed(cfg)
+						progressCb(fmt.Sprintf("IP %s 在并发测速中仅 %.2f MB/s，明显低于分组 '%s' 的基准 %.2f MB/s，将在并发测速结束后单独重测。", job.candidate.IPInfo.Endpoint(), job.contended/1024/1024, job.group.name, baseline/1024/1024))
+						sched.postpone(job)
+						continue
+					}
+					finishJob(job, measurement, urlToTest, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		deferred := sched.takeDeferred()
+		if len(deferred) == 0 {
+			break
+		}
+		progressCb(fmt.Sprintf("单独重测 %d 个受并发测速影响的 IP...", len(deferred)))
+		for _, job := range deferred {
+			measurement, urlToTest, err := runJob(job)
+			if err == nil {
+				progressCb(fmt.Sprintf("IP %s 单独重测: %.2f MB/s（并发时 %.2f MB/s）", job.candidate.IPInfo.Endpoint(), measurement.rankSpeed(cfg)/1024/1024, job.contended/1024/1024))
+			}
+			finishJob(job, measurement, urlToTest, err)
+		}
+		// 重测未通过的分组可能还需要补测，继续下一轮调度
 	}
 
-	wg.Wait()
+	var finalResults []SimplifiedResult
+	for _, g := range sched.groups {
+		progressCb(fmt.Sprintf("分组 '%s' 测试完成，成功获取 %d 个结果。", g.name, len(g.results)))
+		finalResults = append(finalResults, g.results...)
+	}
 	return finalResults
 }
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"sort"
+	"sync"
+)
+
+// speedGroup 是一个分组在速度测试阶段的状态
+type speedGroup struct {
+	name       string
+	candidates []model.LatencyResult
+	next       int // 下一个待测候选的下标
+	pending    int // 正在测试或等待单独重测的候选数
+	results    []SimplifiedResult
+	baseline   float64 // 该分组单独测出的基准速度（B/s），0 表示没有基准
+}
+
+// speedJob 是一次分配给测速 worker 的任务
+type speedJob struct {
+	group      *speedGroup
+	candidate  model.LatencyResult
+	overlapped bool    // 测速期间是否有其他测速同时进行
+	contended  float64 // 因并发影响而推迟时测得的速度（B/s），用于日志
+}
+
+// speedScheduler 在各分组之间轮流分配速度测试任务，并记录哪些测速与其他测速同时进行。
+// 每个分组正在进行与已完成的测速数不超过 topN，避免为已经够数的分组多测。
+type speedScheduler struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	groups   []*speedGroup
+	cursor   int // 轮询分组的位置
+	topN     int
+	running  map[*speedJob]struct{}
+	deferred []*speedJob // 因并发测速互相影响而等待单独重测的任务
+}
+
+func newSpeedScheduler(groupedResults map[string][]model.LatencyResult, topN int) *speedScheduler {
+	s := &speedScheduler{topN: topN, running: make(map[*speedJob]struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	for name, candidates := range groupedResults {
+		s.groups = append(s.groups, &speedGroup{name: name, candidates: candidates})
+	}
+	// 固定分组顺序，使测速顺序可以复现
+	sort.Slice(s.groups, func(i, j int) bool { return s.groups[i].name < s.groups[j].name })
+	return s
+}
+
+// take 按轮询顺序取出下一个任务并标记为正在进行。暂时没有可分配的任务但仍有测速在进行时阻塞等待，
+// 因为进行中的测速失败后其分组可能需要补测；没有任何任务可做时返回 false。
+func (s *speedScheduler) take() (*speedJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for i := range s.groups {
+			g := s.groups[(s.cursor+i)%len(s.groups)]
+			if !s.needsMore(g) {
+				continue
+			}
+			s.cursor = (s.cursor + i + 1) % len(s.groups)
+			return s.newJob(g), true
+		}
+		if len(s.running) == 0 {
+			return nil, false
+		}
+		s.cond.Wait()
+	}
+}
+
+// takeFrom 从指定分组取出下一个任务，分组不再需要测速时返回 false，不会阻塞
+func (s *speedScheduler) takeFrom(g *speedGroup) (*speedJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.needsMore(g) {
+		return nil, false
+	}
+	return s.newJob(g), true
+}
+
+// needsMore 判断分组是否还有候选且尚未获得足够的结果，调用方需持有锁
+func (s *speedScheduler) needsMore(g *speedGroup) bool {
+	return g.next < len(g.candidates) && len(g.results)+g.pending < s.topN
+}
+
+// newJob 为分组的下一个候选创建任务并标记为正在进行，调用方需持有锁
+func (s *speedScheduler) newJob(g *speedGroup) *speedJob {
+	job := &speedJob{group: g, candidate: g.candidates[g.next]}
+	g.next++
+	g.pending++
+	s.start(job)
+	return job
+}
+
+// start 将任务标记为正在进行，并标记与之同时进行的测速
+func (s *speedScheduler) start(job *speedJob) {
+	for other := range s.running {
+		other.overlapped = true
+		job.overlapped = true
+	}
+	s.running[job] = struct{}{}
+}
+
+// stop 将任务标记为测速结束，返回测速期间是否有其他测速同时进行
+func (s *speedScheduler) stop(job *speedJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, job)
+	return job.overlapped
+}
+
+// complete 记录任务的最终结果，result 为 nil 表示该候选未通过测速
+func (s *speedScheduler) complete(job *speedJob, result *SimplifiedResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.group.pending--
+	if result != nil {
+		job.group.results = append(job.group.results, *result)
+	}
+	s.cond.Broadcast()
+}
+
+// postpone 将任务放入单独重测队列，任务仍计入分组的 pending
+func (s *speedScheduler) postpone(job *speedJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deferred = append(s.deferred, job)
+	s.cond.Broadcast()
+}
+
+// takeDeferred 取出所有等待单独重测的任务
+func (s *speedScheduler) takeDeferred() []*speedJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := s.deferred
+	s.deferred = nil
+	return jobs
+}
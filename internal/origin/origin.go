@@ -0,0 +1,197 @@
+package origin
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDownloadBytes 是 /__down 未指定 bytes 参数时返回的数据量
+	DefaultDownloadBytes = 200000000
+	// DefaultMaxBytes 是单次下载或上传允许的最大数据量
+	DefaultMaxBytes = 1000000000
+	// payloadChunkSize 是写出下载数据时每次写入的块大小
+	payloadChunkSize = 64 * 1024
+)
+
+// Options 是测速源站的运行参数
+type Options struct {
+	Addr     string // 监听地址，例如 ":8080"
+	Colo     string // 直接访问（未经过 Cloudflare）时在 trace 与 cf-ray 中报告的数据中心代码
+	MaxBytes int64  // 单次下载或上传允许的最大数据量，0 表示使用 DefaultMaxBytes
+	CertFile string // TLS 证书，与 KeyFile 同时设置时以 HTTPS 提供服务
+	KeyFile  string
+}
+
+// payload 是下载接口重复写出的数据块
+var payload = make([]byte, payloadChunkSize)
+
+// NewHandler 返回提供 /__down、/__up 与 /cdn-cgi/trace 的 HTTP 处理器，
+// 接口行为与 speed.cloudflare.com 一致，tester 可以直接使用
+func NewHandler(opts Options) http.Handler {
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/__down", func(w http.ResponseWriter, r *http.Request) {
+		handleDownload(w, r, opts.Colo, maxBytes)
+	})
+	mux.HandleFunc("/__up", func(w http.ResponseWriter, r *http.Request) {
+		handleUpload(w, r, opts.Colo, maxBytes)
+	})
+	mux.HandleFunc("/cdn-cgi/trace", func(w http.ResponseWriter, r *http.Request) {
+		handleTrace(w, r, opts.Colo)
+	})
+	return mux
+}
+
+// Start 启动测速源站并阻塞运行
+func Start(opts Options) error {
+	server := &http.Server{
+		Addr:              opts.Addr,
+		Handler:           NewHandler(opts),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if opts.CertFile != "" && opts.KeyFile != "" {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		log.Printf("测速源站已启动: https://%s (/__down, /__up, /cdn-cgi/trace)", displayAddr(opts.Addr))
+		return server.ListenAndServeTLS(opts.CertFile, opts.KeyFile)
+	}
+	log.Printf("测速源站已启动: http://%s (/__down, /__up, /cdn-cgi/trace)", displayAddr(opts.Addr))
+	return server.ListenAndServe()
+}
+
+// handleDownload 返回 bytes 参数指定大小的数据，并设置 Content-Length 以便测速在数据读完时结束
+func handleDownload(w http.ResponseWriter, r *http.Request, colo string, maxBytes int64) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	size := int64(DefaultDownloadBytes)
+	if raw := r.URL.Query().Get("bytes"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid bytes", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	if size > maxBytes {
+		http.Error(w, fmt.Sprintf("bytes exceeds limit %d", maxBytes), http.StatusBadRequest)
+		return
+	}
+
+	setSpeedHeaders(w, r, colo)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	for remaining := size; remaining > 0; {
+		n := min(remaining, int64(len(payload)))
+		if _, err := w.Write(payload[:n]); err != nil {
+			return // 客户端在测速结束时会主动断开
+		}
+		remaining -= n
+	}
+}
+
+// handleUpload 读取并丢弃请求体，返回收到的字节数
+func handleUpload(w http.ResponseWriter, r *http.Request, colo string, maxBytes int64) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	received, err := io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, maxBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("body exceeds limit %d", maxBytes), http.StatusRequestEntityTooLarge)
+		}
+		return // 上传测速超时后客户端会中断请求
+	}
+	setSpeedHeaders(w, r, colo)
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "received=%d\n", received)
+}
+
+// handleTrace 以 /cdn-cgi/trace 的 "key=value" 格式返回连接信息
+func handleTrace(w http.ResponseWriter, r *http.Request, colo string) {
+	setSpeedHeaders(w, r, colo)
+	w.Header().Set("Content-Type", "text/plain")
+
+	clientIP := r.Header.Get("CF-Connecting-IP")
+	if clientIP == "" {
+		clientIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	loc := r.Header.Get("CF-IPCountry")
+	if loc == "" {
+		loc = "XX"
+	}
+	tlsVersion, sni := "off", "off"
+	if r.TLS != nil {
+		tlsVersion = tls.VersionName(r.TLS.Version)
+		sni = "plaintext"
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	fmt.Fprintf(w, "fl=origin\n")
+	fmt.Fprintf(w, "h=%s\n", r.Host)
+	fmt.Fprintf(w, "ip=%s\n", clientIP)
+	fmt.Fprintf(w, "ts=%.3f\n", float64(time.Now().UnixMilli())/1000)
+	fmt.Fprintf(w, "visit_scheme=%s\n", scheme)
+	fmt.Fprintf(w, "uag=%s\n", r.UserAgent())
+	fmt.Fprintf(w, "colo=%s\n", requestColo(r, colo))
+	fmt.Fprintf(w, "http=%s\n", strings.ToLower(r.Proto))
+	fmt.Fprintf(w, "loc=%s\n", loc)
+	fmt.Fprintf(w, "tls=%s\n", tlsVersion)
+	fmt.Fprintf(w, "sni=%s\n", sni)
+	fmt.Fprintf(w, "warp=off\n")
+}
+
+// setSpeedHeaders 设置禁止缓存的头部以及 cf-ray 风格的数据中心头部。
+// 经过 Cloudflare 时这些头部会被边缘节点覆盖，直接访问时 tester 同样可以从中读取数据中心。
+func setSpeedHeaders(w http.ResponseWriter, r *http.Request, colo string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if c := requestColo(r, colo); c != "" {
+		w.Header().Set("Server", "cloudflare")
+		w.Header().Set("cf-ray", rayID()+"-"+c)
+	}
+}
+
+// requestColo 优先从 Cloudflare 转发的 Cf-Ray 请求头（例如 7bd32409eda7b020-SJC）中获取边缘节点的数据中心
+func requestColo(r *http.Request, colo string) string {
+	if _, c, found := strings.Cut(r.Header.Get("Cf-Ray"), "-"); found && c != "" {
+		return c
+	}
+	return strings.ToUpper(colo)
+}
+
+// rayID 生成一个与 cf-ray 格式相同的 16 位十六进制随机 ID
+func rayID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// displayAddr 将只有端口的监听地址补全为可访问的形式
+func displayAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+	return addr
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
 // WriteCSVFile 将最终结果列表写入到指定的 CSV 文件中
@@ -21,12 +23,54 @@ func WriteCSVFile(filePath string, results []engine.SimplifiedResult) error {
 	// 写入表头
 	header := []string{
 		"IP Address",
+		"Port",
 		"Source Domain",
 		"Delay (ms)",
+		"Delay CI Low (ms)",
+		"Delay CI High (ms)",
+		"Samples",
+		"Min Delay (ms)",
+		"Median Delay (ms)",
+		"P90 Delay (ms)",
+		"Max Delay (ms)",
+		"Jitter (ms)",
+		"Connect (ms)",
+		"TLS (ms)",
+		"TTFB (ms)",
 		"Loss Rate (%)",
 		"Colo",
 		"Region",
+		"Client IP",
+		"Country",
+		"HTTP Version",
+		"TLS Version",
+		"Prefix",
+		"Origin ASN",
 		"Download Speed (MB/s)",
+		"Stream Speeds (MB/s)",
+		"Speed URL",
+		"Upload Speed (MB/s)",
+		"QUIC Delay (ms)",
+		"QUIC Loss Rate (%)",
+		"QUIC Download Speed (MB/s)",
+		"Kernel RTT (ms)",
+		"Kernel RTT Var (ms)",
+		"Retransmits",
+		"Lost Segments",
+		"Out Of Order",
+		"Delivery Rate (MB/s)",
+		"TCP Loss Ratio (%)",
+		"Upload Retransmits",
+		"Upload TCP Loss Ratio (%)",
+		"Loaded Delay (ms)",
+		"Latency Increase (ms)",
+		"Bufferbloat Grade",
+		"Burst Speed (MB/s)",
+		"Sustained Speed (MB/s)",
+		"Stalls",
+		"Shaping Pattern",
+		"Data Usage (MB)",
+		"Throughput Curve (MB/s)",
 	}
 	if err := writer.Write(header); err != nil {
 		return fmt.Errorf("写入 CSV 表头失败: %w", err)
@@ -40,12 +84,54 @@ func WriteCSVFile(filePath string, results []engine.SimplifiedResult) error {
 	for _, r := range humanReadableResults {
 		row := []string{
 			r.Address,
+			strconv.Itoa(r.Port),
 			r.SourceDomain,
 			fmt.Sprintf("%.2f", r.DelayMS),
+			fmt.Sprintf("%.2f", r.DelayCILowMS),
+			fmt.Sprintf("%.2f", r.DelayCIHighMS),
+			strconv.Itoa(r.Samples),
+			fmt.Sprintf("%.2f", r.MinDelayMS),
+			fmt.Sprintf("%.2f", r.MedianDelayMS),
+			fmt.Sprintf("%.2f", r.P90DelayMS),
+			fmt.Sprintf("%.2f", r.MaxDelayMS),
+			fmt.Sprintf("%.2f", r.JitterMS),
+			fmt.Sprintf("%.2f", r.ConnectMS),
+			fmt.Sprintf("%.2f", r.TLSMS),
+			fmt.Sprintf("%.2f", r.TTFBMS),
 			fmt.Sprintf("%.2f", r.LossRate*100),
 			r.Colo,
 			r.Region,
+			r.ClientIP,
+			r.Country,
+			r.HTTPVersion,
+			r.TLSVersion,
+			r.Prefix,
+			formatASN(r.OriginASN),
 			fmt.Sprintf("%.2f", r.DownloadSpeedMBps), // 使用转换后的 MB/s
+			formatSpeeds(r.StreamSpeedsMBps),
+			r.SpeedURL,
+			fmt.Sprintf("%.2f", r.UploadSpeedMBps),
+			fmt.Sprintf("%.2f", r.QUICDelayMS),
+			fmt.Sprintf("%.2f", r.QUICLossRate*100),
+			fmt.Sprintf("%.2f", r.QUICDownloadSpeedMBps),
+			fmt.Sprintf("%.2f", r.KernelRTTMS),
+			fmt.Sprintf("%.2f", r.KernelRTTVarMS),
+			strconv.FormatUint(uint64(r.Retransmits), 10),
+			strconv.FormatUint(uint64(r.LostSegments), 10),
+			strconv.FormatUint(uint64(r.OutOfOrder), 10),
+			fmt.Sprintf("%.2f", r.DeliveryRateMBps),
+			fmt.Sprintf("%.3f", r.TCPLossRatio*100),
+			strconv.FormatUint(uint64(r.UploadRetransmits), 10),
+			fmt.Sprintf("%.3f", r.UploadTCPLossRatio*100),
+			fmt.Sprintf("%.2f", r.LoadedDelayMS),
+			fmt.Sprintf("%.2f", r.LatencyIncreaseMS),
+			r.BufferbloatGrade,
+			fmt.Sprintf("%.2f", r.BurstSpeedMBps),
+			fmt.Sprintf("%.2f", r.SustainedSpeedMBps),
+			strconv.Itoa(r.Stalls),
+			r.ShapingPattern,
+			fmt.Sprintf("%.2f", r.DataUsageMB),
+			formatSpeeds(r.ThroughputMBps),
 		}
 		if err := writer.Write(row); err != nil {
 			// 记录错误但继续尝试写入其他行
@@ -55,3 +141,23 @@ func WriteCSVFile(filePath string, results []engine.SimplifiedResult) error {
 
 	return writer.Error()
 }
+
+// formatASN 将 ASN 格式化为 "AS13335"，未知时返回空字符串
+func formatASN(asn uint32) string {
+	if asn == 0 {
+		return ""
+	}
+	return fmt.Sprintf("AS%d", asn)
+}
+
+// formatSpeeds 将多连接测速中各连接的速度以 " / " 连接，单连接时返回空字符串
+func formatSpeeds(speeds []float64) string {
+	if len(speeds) < 2 {
+		return ""
+	}
+	parts := make([]string, len(speeds))
+	for i, s := range speeds {
+		parts[i] = fmt.Sprintf("%.2f", s)
+	}
+	return strings.Join(parts, " / ")
+}
@@ -4,13 +4,58 @@ import "Domain_IP_Selector_Go/internal/engine"
 
 // HumanReadableResult 定义了一个对人类友好的、用于最终文件输出的数据结构
 type HumanReadableResult struct {
-	Address           string  `json:"Address"`
-	SourceDomain      string  `json:"SourceDomain"`
-	DelayMS           float64 `json:"DelayMS"`  // 延迟 (毫秒)
-	LossRate          float64 `json:"LossRate"` // 丢包率
-	Colo              string  `json:"Colo"`
-	Region            string  `json:"Region"`
-	DownloadSpeedMBps float64 `json:"DownloadSpeedMBps"` // 下载速度 (MB/s)
+	Address               string  `json:"Address"`
+	Port                  int     `json:"Port"`
+	SourceDomain          string  `json:"SourceDomain"`
+	DelayMS               float64 `json:"DelayMS"`       // 延迟 (毫秒)
+	DelayCILowMS          float64 `json:"DelayCILowMS"`  // 延迟 95% 置信区间下限 (毫秒)
+	DelayCIHighMS         float64 `json:"DelayCIHighMS"` // 延迟 95% 置信区间上限 (毫秒)
+	Samples               int     `json:"Samples"`
+	MinDelayMS            float64 `json:"MinDelayMS"`
+	MedianDelayMS         float64 `json:"MedianDelayMS"`
+	P90DelayMS            float64 `json:"P90DelayMS"`
+	MaxDelayMS            float64 `json:"MaxDelayMS"`
+	JitterMS              float64 `json:"JitterMS"`  // 抖动 (毫秒)
+	ConnectMS             float64 `json:"ConnectMS"` // TCP 连接耗时 (毫秒)
+	TLSMS                 float64 `json:"TLSMS"`     // TLS 握手耗时 (毫秒)
+	TTFBMS                float64 `json:"TTFBMS"`    // 首字节耗时 (毫秒)
+	LossRate              float64 `json:"LossRate"`  // 丢包率
+	Colo                  string  `json:"Colo"`
+	Region                string  `json:"Region"`
+	ClientIP              string  `json:"ClientIP"`
+	Country               string  `json:"Country"`
+	HTTPVersion           string  `json:"HTTPVersion"`
+	TLSVersion            string  `json:"TLSVersion"`
+	Prefix                string  `json:"Prefix"`                // BGP 宣告前缀
+	OriginASN             uint32  `json:"OriginASN"`             // 源 ASN
+	DownloadSpeedMBps     float64 `json:"DownloadSpeedMBps"`     // 下载速度 (MB/s)
+	SpeedURL              string  `json:"SpeedURL"`              // 下载测速使用的地址
+	UploadSpeedMBps       float64 `json:"UploadSpeedMBps"`       // 上传速度 (MB/s)
+	QUICDelayMS           float64 `json:"QUICDelayMS"`           // HTTP/3 延迟 (毫秒)
+	QUICLossRate          float64 `json:"QUICLossRate"`          // HTTP/3 丢包率
+	QUICDownloadSpeedMBps float64 `json:"QUICDownloadSpeedMBps"` // HTTP/3 下载速度 (MB/s)
+	KernelRTTMS           float64 `json:"KernelRTTMS"`           // 内核 RTT (毫秒)
+	KernelRTTVarMS        float64 `json:"KernelRTTVarMS"`        // 内核 RTT 方差 (毫秒)
+	Retransmits           uint32  `json:"Retransmits"`
+	LostSegments          uint32  `json:"LostSegments"`
+	OutOfOrder            uint32  `json:"OutOfOrder"`
+	DeliveryRateMBps      float64 `json:"DeliveryRateMBps"` // 内核交付速率 (MB/s)
+	TCPLossRatio          float64 `json:"TCPLossRatio"`
+	UploadRetransmits     uint32  `json:"UploadRetransmits"`
+	UploadTCPLossRatio    float64 `json:"UploadTCPLossRatio"`
+	LoadedDelayMS         float64 `json:"LoadedDelayMS"`     // 下载期间的负载延迟 (毫秒)
+	LatencyIncreaseMS     float64 `json:"LatencyIncreaseMS"` // 负载延迟相对空载延迟的增量 (毫秒)
+	BufferbloatGrade      string  `json:"BufferbloatGrade"`  // 缓冲膨胀等级
+
+	BurstSpeedMBps     float64 `json:"BurstSpeedMBps"`     // 测速开始阶段的突发速度 (MB/s)
+	SustainedSpeedMBps float64 `json:"SustainedSpeedMBps"` // 测速后半段的持续速度 (MB/s)
+	Stalls             int     `json:"Stalls"`             // 下载过程中的停顿次数
+	ShapingPattern     string  `json:"ShapingPattern"`     // 疑似限速形态
+
+	DataUsageMB float64 `json:"DataUsageMB"` // 本次运行中与该 IP 之间的流量 (MB)
+
+	StreamSpeedsMBps []float64 `json:"StreamSpeedsMBps"` // 多连接测速时每个连接的下载速度 (MB/s)
+	ThroughputMBps   []float64 `json:"ThroughputMBps"`   // 下载测速每个时间片的吞吐量 (MB/s)
 }
 
 // ToHumanReadable 将引擎的原始结果转换为对人类友好的格式
@@ -18,14 +63,68 @@ func ToHumanReadable(results []engine.SimplifiedResult) []HumanReadableResult {
 	humanResults := make([]HumanReadableResult, len(results))
 	for i, r := range results {
 		humanResults[i] = HumanReadableResult{
-			Address:           r.Address,
-			SourceDomain:      r.SourceDomain,
-			DelayMS:           float64(r.Delay) / 1000000.0, // 纳秒转毫秒
-			LossRate:          r.LossRate,
-			Colo:              r.Colo,
-			Region:            r.Region,
-			DownloadSpeedMBps: float64(r.DownloadSpeed) / 1024.0, // KB/s 转 MB/s
+			Address:               r.Address,
+			Port:                  r.Port,
+			SourceDomain:          r.SourceDomain,
+			DelayMS:               float64(r.Delay) / 1000000.0, // 纳秒转毫秒
+			DelayCILowMS:          float64(r.DelayCILow) / 1000000.0,
+			DelayCIHighMS:         float64(r.DelayCIHigh) / 1000000.0,
+			Samples:               r.Samples,
+			MinDelayMS:            float64(r.MinDelay) / 1000000.0,
+			MedianDelayMS:         float64(r.MedianDelay) / 1000000.0,
+			P90DelayMS:            float64(r.P90Delay) / 1000000.0,
+			MaxDelayMS:            float64(r.MaxDelay) / 1000000.0,
+			JitterMS:              float64(r.Jitter) / 1000000.0,
+			ConnectMS:             float64(r.ConnectTime) / 1000000.0,
+			TLSMS:                 float64(r.TLSTime) / 1000000.0,
+			TTFBMS:                float64(r.TTFB) / 1000000.0,
+			LossRate:              r.LossRate,
+			Colo:                  r.Colo,
+			Region:                r.Region,
+			ClientIP:              r.ClientIP,
+			Country:               r.Country,
+			HTTPVersion:           r.HTTPVersion,
+			TLSVersion:            r.TLSVersion,
+			Prefix:                r.Prefix,
+			OriginASN:             r.OriginASN,
+			DownloadSpeedMBps:     float64(r.DownloadSpeed) / 1024.0, // KB/s 转 MB/s
+			SpeedURL:              r.SpeedURL,
+			UploadSpeedMBps:       float64(r.UploadSpeed) / 1024.0,
+			QUICDelayMS:           float64(r.QUICDelay) / 1000000.0,
+			QUICLossRate:          r.QUICLossRate,
+			QUICDownloadSpeedMBps: float64(r.QUICDownloadSpeed) / 1024.0,
+			KernelRTTMS:           float64(r.KernelRTT) / 1000000.0,
+			KernelRTTVarMS:        float64(r.KernelRTTVar) / 1000000.0,
+			Retransmits:           r.Retransmits,
+			LostSegments:          r.LostSegments,
+			OutOfOrder:            r.OutOfOrder,
+			DeliveryRateMBps:      float64(r.DeliveryRate) / 1024.0,
+			TCPLossRatio:          r.TCPLossRatio,
+			UploadRetransmits:     r.UploadRetransmits,
+			UploadTCPLossRatio:    r.UploadLossRatio,
+			LoadedDelayMS:         float64(r.LoadedDelay) / 1000000.0,
+			LatencyIncreaseMS:     float64(r.LatencyIncrease) / 1000000.0,
+			BufferbloatGrade:      r.BufferbloatGrade,
+			BurstSpeedMBps:        float64(r.BurstSpeed) / 1024.0,
+			SustainedSpeedMBps:    float64(r.SustainedSpeed) / 1024.0,
+			Stalls:                r.Stalls,
+			ShapingPattern:        r.ShapingPattern,
+			DataUsageMB:           float64(r.DataUsage) / 1024.0 / 1024.0, // 字节转 MB
+			StreamSpeedsMBps:      streamSpeedsMBps(r.StreamSpeeds),
+			ThroughputMBps:        streamSpeedsMBps(r.Throughput),
 		}
 	}
 	return humanResults
 }
+
+// streamSpeedsMBps 将一组速度从 KB/s 转换为 MB/s
+func streamSpeedsMBps(speeds []int) []float64 {
+	if len(speeds) == 0 {
+		return nil
+	}
+	mbps := make([]float64, len(speeds))
+	for i, s := range speeds {
+		mbps[i] = float64(s) / 1024.0
+	}
+	return mbps
+}
@@ -0,0 +1,33 @@
+package output
+
+import (
+	"Domain_IP_Selector_Go/internal/usage"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Metadata 是与结果文件一同写出的运行信息
+type Metadata struct {
+	GeneratedAt string       `json:"GeneratedAt"` // 写出时间（RFC 3339）
+	ResultCount int          `json:"ResultCount"` // 结果文件中的 IP 数
+	DataUsage   usage.Report `json:"DataUsage"`   // 本次运行各阶段与各 IP 的流量（字节）
+}
+
+// WriteMetadataFile 将运行信息写入到指定的 JSON 文件中
+func WriteMetadataFile(filePath string, resultCount int, report usage.Report) error {
+	metadata := Metadata{
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		ResultCount: resultCount,
+		DataUsage:   report,
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("无法将运行信息序列化为 JSON: %w", err)
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("无法写入运行信息文件 '%s': %w", filePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package pool
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// SourceLabel 是从 IP 池合并进候选列表的 IP 的来源标记
+	SourceLabel = "pool"
+
+	// minScore 衰减后的得分低于此值的条目会被清理
+	minScore = 0.1
+)
+
+// Entry 记录一个曾经通过速度测试的 IP
+type Entry struct {
+	Address             string    `json:"address"`
+	FirstSeen           time.Time `json:"first_seen"`
+	LastSeen            time.Time `json:"last_seen"` // 最近一次通过速度测试的时间
+	LastFailed          time.Time `json:"last_failed,omitempty"`
+	SuccessCount        int       `json:"success_count"`
+	FailCount           int       `json:"fail_count"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Score               float64   `json:"score"` // 截至 LastSeen 的得分，读取时按半衰期衰减
+	LastDelayMS         float64   `json:"last_delay_ms"`
+	LastSpeedMBps       float64   `json:"last_speed_mbps"`
+	Colo                string    `json:"colo"`
+}
+
+// Pool 是持久化的优质 IP 池，所有方法都是并发安全的，且允许在 nil 上调用（相当于禁用）。
+// 每次运行加载一次；本次运行的结果先按 IP 汇总，Save 时每个 IP 只记一次：在任一端口上成功即为成功。
+type Pool struct {
+	path     string
+	halfLife time.Duration
+	mu       sync.Mutex
+	entries  map[string]*Entry
+	results  map[string]*runResult // 本次运行尚未写入条目的结果
+}
+
+// runResult 是 IP 在本次运行中所有端口的汇总结果
+type runResult struct {
+	success   bool
+	delay     time.Duration
+	speedMBps float64
+	colo      string
+}
+
+// Load 从指定路径加载 IP 池，文件不存在时返回一个空池
+func Load(path string, halfLife time.Duration) (*Pool, error) {
+	p := &Pool{
+		path:     path,
+		halfLife: halfLife,
+		entries:  make(map[string]*Entry),
+		results:  make(map[string]*runResult),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("无法读取 IP 池文件 '%s': %w", path, err)
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("解析 IP 池文件 '%s' 失败: %w", path, err)
+	}
+	for _, e := range entries {
+		if net.ParseIP(e.Address) != nil {
+			p.entries[e.Address] = e
+		}
+	}
+	return p, nil
+}
+
+// Save 写入本次运行的汇总结果，并将 IP 池按得分倒序写回磁盘
+func (p *Pool) Save() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.commit(now)
+	entries := make([]*Entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return p.decayedScore(entries[i], now) > p.decayedScore(entries[j], now)
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("无法将 IP 池序列化为 JSON: %w", err)
+	}
+	if err := os.WriteFile(p.path, data, 0644); err != nil {
+		return fmt.Errorf("无法写入 IP 池文件 '%s': %w", p.path, err)
+	}
+	return nil
+}
+
+// Len 返回池中的 IP 数量
+func (p *Pool) Len() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// Contains 检查 IP 是否在池中
+func (p *Pool) Contains(ip net.IP) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.entries[ip.String()]
+	return ok
+}
+
+// Candidates 返回池中所有 IP，用于合并进本轮的候选列表
+func (p *Pool) Candidates() []model.IPInfo {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidates := make([]model.IPInfo, 0, len(p.entries))
+	for addr := range p.entries {
+		candidates = append(candidates, model.IPInfo{Address: net.ParseIP(addr), SourceDomain: SourceLabel})
+	}
+	return candidates
+}
+
+// RecordSuccess 记录一次通过速度测试的结果，同一 IP 在多个端口上成功时保留速度最快的一次。
+// 结果在 Save 时写入，不在池中的 IP 届时会被加入。
+func (p *Pool) RecordSuccess(ip net.IP, delay time.Duration, speedMBps float64, colo string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r := p.result(ip.String())
+	if r.success && speedMBps <= r.speedMBps {
+		return
+	}
+	*r = runResult{success: true, delay: delay, speedMBps: speedMBps, colo: colo}
+}
+
+// RecordFailure 记录池中 IP 的一次测试失败，不在池中的 IP 会被忽略。
+// 只有本次运行在所有端口上都失败的 IP 才会在 Save 时记为失败。
+func (p *Pool) RecordFailure(ip net.IP) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addr := ip.String()
+	if _, ok := p.entries[addr]; !ok {
+		return
+	}
+	p.result(addr)
+}
+
+// result 返回 IP 在本次运行中的汇总结果，调用方需持有锁
+func (p *Pool) result(addr string) *runResult {
+	r, ok := p.results[addr]
+	if !ok {
+		r = &runResult{}
+		p.results[addr] = r
+	}
+	return r
+}
+
+// commit 将本次运行的汇总结果写入条目并清空，调用方需持有锁
+func (p *Pool) commit(now time.Time) {
+	for addr, r := range p.results {
+		e, ok := p.entries[addr]
+		if !r.success {
+			if ok {
+				e.LastFailed = now
+				e.FailCount++
+				e.ConsecutiveFailures++
+			}
+			continue
+		}
+		if !ok {
+			e = &Entry{Address: addr, FirstSeen: now}
+			p.entries[addr] = e
+		}
+		e.Score = p.decayedScore(e, now) + 1
+		e.LastSeen = now
+		e.SuccessCount++
+		e.ConsecutiveFailures = 0
+		e.LastDelayMS = float64(r.delay) / float64(time.Millisecond)
+		e.LastSpeedMBps = r.speedMBps
+		e.Colo = r.colo
+	}
+	p.results = make(map[string]*runResult)
+}
+
+// Prune 清理过期、得分过低或连续失败次数过多的条目，返回被清理的数量
+func (p *Pool) Prune(maxAge time.Duration, maxFailures int) int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for addr, e := range p.entries {
+		if (maxAge > 0 && now.Sub(e.LastSeen) > maxAge) ||
+			(maxFailures > 0 && e.ConsecutiveFailures >= maxFailures) ||
+			p.decayedScore(e, now) < minScore {
+			delete(p.entries, addr)
+			removed++
+		}
+	}
+	return removed
+}
+
+// decayedScore 计算条目在 now 时刻按半衰期衰减后的得分
+func (p *Pool) decayedScore(e *Entry, now time.Time) float64 {
+	if p.halfLife <= 0 || e.LastSeen.IsZero() {
+		return e.Score
+	}
+	elapsed := now.Sub(e.LastSeen)
+	return e.Score * math.Pow(0.5, float64(elapsed)/float64(p.halfLife))
+}
@@ -0,0 +1,243 @@
+package quarantine
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind 表示一次失败（strike）的类型
+type Kind string
+
+const (
+	KindTimeout  Kind = "timeout"   // 连接或请求超时
+	KindStatus   Kind = "status"    // 返回了非预期的 HTTP 状态码
+	KindLowSpeed Kind = "low_speed" // 下载速度接近于零
+)
+
+// Entry 是隔离文件中的一条记录。自动记录的条目以 "IP:端口" 为 address，只隔离该端口。
+// 手动添加时只需填写 address（可以是 IP、CIDR 或 IP:端口），permanent 为 true 表示永久隔离。
+type Entry struct {
+	Address    string       `json:"address"`
+	Reason     string       `json:"reason,omitempty"`
+	Permanent  bool         `json:"permanent,omitempty"`
+	Until      time.Time    `json:"until,omitzero"`       // 隔离截止时间，零值表示尚未被隔离
+	Strikes    map[Kind]int `json:"strikes,omitempty"`    // 尚未达到阈值的累计失败次数
+	LastStrike time.Time    `json:"last_strike,omitzero"` // 最近一次失败的时间
+}
+
+// List 是持久化的隔离列表，所有方法都是并发安全的，且允许在 nil 上调用（相当于禁用）
+type List struct {
+	path       string
+	thresholds map[Kind]int
+	expiry     time.Duration
+	mu         sync.Mutex
+	entries    map[string]*Entry
+	nets       []*net.IPNet // 手动添加的 CIDR 条目
+}
+
+// Load 从指定路径加载隔离列表，文件不存在时返回一个空列表。
+// 已过期的隔离与超过 expiry 未再失败的 strike 会在加载时被清除。
+func Load(path string, thresholds map[Kind]int, expiry time.Duration) (*List, error) {
+	l := &List{
+		path:       path,
+		thresholds: thresholds,
+		expiry:     expiry,
+		entries:    make(map[string]*Entry),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("无法读取隔离文件 '%s': %w", path, err)
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("解析隔离文件 '%s' 失败: %w", path, err)
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		e.Address = strings.TrimSpace(e.Address)
+		if !e.Permanent && !e.Until.IsZero() && now.After(e.Until) {
+			e.Until = time.Time{}
+			e.Strikes = nil
+		}
+		if expiry > 0 && now.Sub(e.LastStrike) > expiry {
+			e.Strikes = nil
+		}
+		if !e.Permanent && e.Until.IsZero() && len(e.Strikes) == 0 && !e.LastStrike.IsZero() {
+			continue // 自动生成且已失效的条目
+		}
+
+		if ip := net.ParseIP(e.Address); ip != nil {
+			l.entries[ip.String()] = e
+		} else if _, ipNet, err := net.ParseCIDR(e.Address); err == nil {
+			l.entries[e.Address] = e
+			l.nets = append(l.nets, ipNet)
+		} else if host, portStr, err := net.SplitHostPort(e.Address); err == nil {
+			port, err := strconv.Atoi(portStr)
+			if ip := net.ParseIP(host); ip != nil && err == nil {
+				l.entries[endpointKey(ip, port)] = e
+			}
+		}
+	}
+	return l, nil
+}
+
+// Save 将隔离列表写回磁盘
+func (l *List) Save() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]*Entry, 0, len(l.entries))
+	for _, e := range l.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Address < entries[j].Address
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("无法将隔离列表序列化为 JSON: %w", err)
+	}
+	if err := os.WriteFile(l.path, data, 0644); err != nil {
+		return fmt.Errorf("无法写入隔离文件 '%s': %w", l.path, err)
+	}
+	return nil
+}
+
+// IsQuarantined 检查 IP 的指定端口当前是否处于隔离状态，整个 IP 或所在网段被隔离时所有端口都视为隔离
+func (l *List) IsQuarantined(ip net.IP, port int) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.isQuarantined(ip, port, time.Now())
+}
+
+func (l *List) isQuarantined(ip net.IP, port int, now time.Time) bool {
+	if e, ok := l.entries[ip.String()]; ok && e.active(now) {
+		return true
+	}
+	if e, ok := l.entries[endpointKey(ip, port)]; ok && e.active(now) {
+		return true
+	}
+	for _, n := range l.nets {
+		if n.Contains(ip) {
+			if e := l.entries[n.String()]; e != nil && e.active(now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter 移除处于隔离状态的 IP，返回剩余的 IP 及被移除的数量
+func (l *List) Filter(ips []model.IPInfo) ([]model.IPInfo, int) {
+	if l == nil {
+		return ips, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var kept []model.IPInfo
+	for _, ipInfo := range ips {
+		if !l.isQuarantined(ipInfo.Address, ipInfo.Port, now) {
+			kept = append(kept, ipInfo)
+		}
+	}
+	return kept, len(ips) - len(kept)
+}
+
+// Strike 为 IP 的指定端口记录一次失败，累计次数达到该类型的阈值时隔离该端口，返回是否因此进入隔离。
+// 按端口分别统计，避免只封锁了个别端口的网络使 IP 在所有端口上被隔离。
+func (l *List) Strike(ip net.IP, port int, kind Kind) bool {
+	if l == nil {
+		return false
+	}
+	threshold := l.thresholds[kind]
+	if threshold <= 0 {
+		return false // 阈值为 0 表示不统计此类失败
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	addr := endpointKey(ip, port)
+	e, ok := l.entries[addr]
+	if !ok {
+		e = &Entry{Address: addr}
+		l.entries[addr] = e
+	}
+	if e.Strikes == nil {
+		e.Strikes = make(map[Kind]int)
+	}
+	e.Strikes[kind]++
+	e.LastStrike = now
+
+	if e.Strikes[kind] < threshold || e.active(now) {
+		return false
+	}
+	e.Until = now.Add(l.expiry)
+	e.Reason = fmt.Sprintf("连续 %d 次 %s", e.Strikes[kind], kind)
+	e.Strikes = nil
+	return true
+}
+
+// Clear 在 IP 的指定端口测试成功后清除该端口累计的失败次数，使阈值只统计连续的失败。
+// 指定 kinds 时只清除这些类型，否则清除全部类型。处于隔离中的条目与手动添加的条目不受影响。
+func (l *List) Clear(ip net.IP, port int, kinds ...Kind) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	addr := endpointKey(ip, port)
+	e, ok := l.entries[addr]
+	if !ok || e.Permanent || e.active(time.Now()) {
+		return
+	}
+	for _, kind := range kinds {
+		delete(e.Strikes, kind)
+	}
+	if len(kinds) == 0 || len(e.Strikes) == 0 {
+		delete(l.entries, addr)
+	}
+}
+
+// endpointKey 返回自动记录的条目使用的 "IP:端口" 形式的键，端口为 0 时只使用 IP
+func endpointKey(ip net.IP, port int) string {
+	if port == 0 {
+		return ip.String()
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// active 判断条目在 now 时刻是否处于隔离状态
+func (e *Entry) active(now time.Time) bool {
+	if e.Permanent {
+		return true
+	}
+	if e.Until.IsZero() {
+		// 手动添加且未填写任何状态的条目视为永久隔离
+		return len(e.Strikes) == 0 && e.LastStrike.IsZero()
+	}
+	return now.Before(e.Until)
+}
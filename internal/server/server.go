@@ -5,6 +5,7 @@ import (
 	"Domain_IP_Selector_Go/internal/engine"
 	"Domain_IP_Selector_Go/internal/locations"
 	"Domain_IP_Selector_Go/internal/output"
+	"Domain_IP_Selector_Go/internal/usage"
 	"bytes"
 	"context"
 	"embed"
@@ -17,6 +18,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -226,7 +228,7 @@ func handleWebSocket(cfgPath, locationsPath, domainsPath, exeDir string) http.Ha
 
 		// Define a structured message for WebSocket communication
 		type WebSocketMessage struct {
-			Type    string      `json:"type"` // "log" or "result"
+			Type    string      `json:"type"` // "log", "usage" or "result"
 			Payload interface{} `json:"payload"`
 		}
 
@@ -253,8 +255,38 @@ func handleWebSocket(cfgPath, locationsPath, domainsPath, exeDir string) http.Ha
 			}
 		}
 
+		// Periodically push data usage so the page can show how much traffic the run has consumed so far
+		meter := usage.NewMeter()
+		sendUsage := func() {
+			select {
+			case <-ctx.Done():
+			default:
+				writeChan <- WebSocketMessage{Type: "usage", Payload: meter.Report()}
+			}
+		}
+		stopUsage := make(chan struct{})
+		usageDone := make(chan struct{})
+		go func() {
+			defer close(usageDone)
+			ticker := time.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					sendUsage()
+				case <-stopUsage:
+					return
+				}
+			}
+		}()
+
 		// 5. Run the engine in the main handler goroutine
-		finalResults, err := engine.Run(runConfig, locationsPath, domainsPath, exeDir, progressCallback)
+		finalResults, err := engine.Run(runConfig, locationsPath, domainsPath, exeDir, meter, progressCallback)
+		close(stopUsage)
+		<-usageDone
+		sendUsage()
+		// The save goroutines report through progressCallback, so they must finish before writeChan is closed
+		var saves sync.WaitGroup
 		if err != nil {
 			errMsg := fmt.Sprintf("引擎运行时出错: %v", err)
 			progressCallback(errMsg)
@@ -275,8 +307,11 @@ func handleWebSocket(cfgPath, locationsPath, domainsPath, exeDir string) http.Ha
 				}
 				jsonFileName := fmt.Sprintf("web_result_%s.json", ipVersion)
 				csvFileName := fmt.Sprintf("web_result_%s.csv", ipVersion)
+				metaFileName := fmt.Sprintf("web_result_%s_meta.json", ipVersion)
 
+				saves.Add(1)
 				go func() {
+					defer saves.Done()
 					if err := output.WriteJSONFile(jsonFileName, finalResults); err != nil {
 						log.Printf("保存 JSON 文件失败: %v", err)
 						progressCallback(fmt.Sprintf("错误: 保存 %s 失败。", jsonFileName))
@@ -285,7 +320,20 @@ func handleWebSocket(cfgPath, locationsPath, domainsPath, exeDir string) http.Ha
 					}
 				}()
 
+				saves.Add(1)
+				go func() {
+					defer saves.Done()
+					if err := output.WriteMetadataFile(metaFileName, len(finalResults), meter.Report()); err != nil {
+						log.Printf("保存运行信息失败: %v", err)
+						progressCallback(fmt.Sprintf("错误: 保存 %s 失败。", metaFileName))
+					} else {
+						progressCallback(fmt.Sprintf("结果已保存到 %s", metaFileName))
+					}
+				}()
+
+				saves.Add(1)
 				go func() {
+					defer saves.Done()
 					if err := output.WriteCSVFile(csvFileName, finalResults); err != nil {
 						log.Printf("保存 CSV 文件失败: %v", err)
 						progressCallback(fmt.Sprintf("错误: 保存 %s 失败。", csvFileName))
@@ -297,6 +345,7 @@ func handleWebSocket(cfgPath, locationsPath, domainsPath, exeDir string) http.Ha
 		}
 
 		// 6. After the engine is done, close the connection
+		saves.Wait()
 		progressCallback("--- 任务完成 ---")
 		close(writeChan)                   // Close the channel to signal the writer goroutine to exit
 		time.Sleep(200 * time.Millisecond) // Give writer goroutine a moment to send the last message
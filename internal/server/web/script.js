@@ -7,6 +7,7 @@ document.addEventListener('DOMContentLoaded', () => {
     const resultsPanel = document.getElementById('results-panel');
     const resultsTableContainer = document.getElementById('results-table-container');
     const copyAllBtn = document.getElementById('copy-all-ips');
+    const dataUsage = document.getElementById('data-usage');
 
 
     let currentConfig = {};
@@ -28,6 +29,8 @@ document.addEventListener('DOMContentLoaded', () => {
                 const message = JSON.parse(event.data);
                 if (message.type === 'log') {
                     appendLog(message.payload);
+                } else if (message.type === 'usage') {
+                    displayUsage(message.payload);
                 } else if (message.type === 'result') {
                     displayResults(message.payload);
                 }
@@ -117,10 +120,18 @@ document.addEventListener('DOMContentLoaded', () => {
 
         // Editable settings
         editableForm.appendChild(createFormGroup('max_latency', '最大延迟 (ms)'));
+        editableForm.appendChild(createFormGroup('max_jitter', '最大抖动 (ms, 0为不限制)'));
         editableForm.appendChild(createFormGroup('speedtest_rate_limit_mb', '速度上限 (MB/s, 0为不限速)'));
+        editableForm.appendChild(createFormGroup('speedtest_tolerance', '测速收敛容差 (0为测满最长时长)'));
         editableForm.appendChild(createFormGroup('min_speed', '最小速度 (MB/s, 0为不限速)'));
+        editableForm.appendChild(createFormGroup('min_upload_speed', '最小上传速度 (MB/s, 0为不限速)'));
+        editableForm.appendChild(createFormGroup('max_bufferbloat_grade', '缓冲膨胀等级要求', 'select', { choices: [{value: '', text: '不限制'}, {value: 'A+', text: 'A+'}, {value: 'A', text: 'A 及以上'}, {value: 'B', text: 'B 及以上'}, {value: 'C', text: 'C 及以上'}, {value: 'D', text: 'D 及以上'}] }));
+        editableForm.appendChild(createFormGroup('latency_mode', '延迟测试方式', 'select', { choices: [{value: 'httping', text: 'HTTPing'}, {value: 'tcping', text: 'TCPing'}, {value: 'hybrid', text: 'TCPing 预筛 + HTTPing'}] }));
+        editableForm.appendChild(createFormGroup('screen_mode', '握手预筛', 'select', { choices: [{value: '', text: '不预筛'}, {value: 'tcp', text: 'TCP 握手'}, {value: 'tls', text: 'TLS 握手'}] }));
+        editableForm.appendChild(createFormGroup('plain_http', '明文 HTTP 模式', 'select', { choices: [{value: 'false', text: '关闭 (HTTPS)'}, {value: 'true', text: '开启 (仅 HTTP 端口)'}] }));
+        editableForm.appendChild(createFormGroup('tls_fingerprint', 'TLS 指纹', 'select', { choices: [{value: '', text: '默认 (Go)'}, {value: 'chrome', text: 'Chrome'}, {value: 'firefox', text: 'Firefox'}, {value: 'safari', text: 'Safari'}] }));
         editableForm.appendChild(createFormGroup('ip_version', 'IP 版本', 'select', { choices: [{value: 'ipv4', text: 'IPv4'}, {value: 'ipv6', text: 'IPv6'}] }));
-        editableForm.appendChild(createFormGroup('group_by', '分组方式', 'select', { choices: [{value: 'region', text: '按地理区域'}, {value: 'colo', text: '按数据中心'}] }));
+        editableForm.appendChild(createFormGroup('group_by', '分组方式', 'select', { choices: [{value: 'region', text: '按地理区域'}, {value: 'colo', text: '按数据中心'}, {value: 'prefix', text: '按 BGP 前缀'}, {value: 'asn', text: '按源 ASN'}, {value: 'port', text: '按端口'}] }));
         
         // Tag-based filters
         editableForm.appendChild(createFormGroup('filter_regions', '筛选区域 (留空则全选)', 'tags'));
@@ -181,6 +192,8 @@ document.addEventListener('DOMContentLoaded', () => {
             }
         });
         
+        config.plain_http = config.plain_http === 'true';
+
         // Get selected tags
         config.filter_regions = Array.from(document.querySelectorAll('#filter_regions .tag-btn.selected')).map(btn => btn.dataset.tag);
         config.filter_colos = Array.from(document.querySelectorAll('#filter_colos .tag-btn.selected')).map(btn => btn.dataset.tag);
@@ -215,6 +228,7 @@ document.addEventListener('DOMContentLoaded', () => {
         runTestBtn.innerHTML = '<span class="icon">⏳</span> 测试中...';
 
         progressLog.textContent = ''; // Clear log on new run
+        dataUsage.textContent = '已用流量: -';
         resultsPanel.style.display = 'none'; // Hide previous results
         connectWebSocket();
         // Use a short timeout to ensure socket is ready before sending
@@ -319,6 +333,21 @@ document.addEventListener('DOMContentLoaded', () => {
         progressLogContainer.scrollTop = progressLogContainer.scrollHeight;
     }
 
+    function formatBytes(bytes) {
+        if (bytes >= 1024 * 1024 * 1024) return `${(bytes / 1024 / 1024 / 1024).toFixed(2)} GB`;
+        if (bytes >= 1024 * 1024) return `${(bytes / 1024 / 1024).toFixed(2)} MB`;
+        if (bytes >= 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+        return `${bytes} B`;
+    }
+
+    // 显示本次运行的流量：总量与各阶段流量
+    function displayUsage(report) {
+        const stageNames = { dns: 'DNS', latency: '延迟', speed: '测速' };
+        const total = report.Total.Sent + report.Total.Received;
+        const stages = report.Stages.map(s => `${stageNames[s.Stage] || s.Stage} ${formatBytes(s.Sent + s.Received)}`);
+        dataUsage.textContent = `已用流量: ${formatBytes(total)}` + (stages.length > 0 ? ` (${stages.join(', ')})` : '') + `，涉及 ${report.IPs.length} 个 IP`;
+    }
+
     function displayResults(results) {
         resultsPanel.style.display = 'block';
         resultsTableContainer.innerHTML = ''; // Clear previous table
@@ -334,7 +363,7 @@ document.addEventListener('DOMContentLoaded', () => {
         // Header
         const thead = table.createTHead();
         const headerRow = thead.insertRow();
-        const headers = ['IP 地址', '延迟 (ms)', '下载速度 (MB/s)', '数据中心', '地理区域', '操作'];
+        const headers = ['IP 地址', '端口', '延迟 (ms)', '抖动 (ms)', '下载速度 (MB/s)', '上传速度 (MB/s)', '负载延迟 (ms)', '限速检测', '流量', 'HTTP/3 延迟 / 速度', '数据中心', '地理区域', 'BGP 前缀', '操作'];
         headers.forEach(text => {
             const th = document.createElement('th');
             th.textContent = text;
@@ -346,10 +375,24 @@ document.addEventListener('DOMContentLoaded', () => {
         results.forEach(res => {
             const row = tbody.insertRow();
             row.insertCell().textContent = res.Address;
-            row.insertCell().textContent = (res.Delay / 1000000).toFixed(2); // 纳秒转毫秒
-            row.insertCell().textContent = (res.DownloadSpeed / 1024).toFixed(2); // KB/s to MB/s
+            row.insertCell().textContent = res.Port || '-';
+            row.insertCell().textContent = res.DelayCIHigh > res.DelayCILow
+                ? `${(res.Delay / 1000000).toFixed(2)} ±${((res.DelayCIHigh - res.DelayCILow) / 2000000).toFixed(2)}`
+                : (res.Delay / 1000000).toFixed(2); // 纳秒转毫秒
+            row.insertCell().textContent = (res.Jitter / 1000000).toFixed(2);
+            const speedCell = row.insertCell();
+            speedCell.textContent = (res.DownloadSpeed / 1024).toFixed(2) + (res.StreamSpeeds && res.StreamSpeeds.length > 1 ? ` (${res.StreamSpeeds.length} 连接)` : ''); // KB/s to MB/s
+            speedCell.title = res.SpeedURL ? `测速地址: ${res.SpeedURL}` : '';
+            const uploadCell = row.insertCell();
+            uploadCell.textContent = res.UploadSpeed ? (res.UploadSpeed / 1024).toFixed(2) : '-';
+            uploadCell.title = res.UploadSpeed ? `上传重传: ${res.UploadRetransmits} 段, 丢包比例: ${(res.UploadLossRatio * 100).toFixed(3)}%` : '';
+            row.insertCell().textContent = res.BufferbloatGrade ? `${(res.LoadedDelay / 1000000).toFixed(2)} (+${(res.LatencyIncrease / 1000000).toFixed(2)}, ${res.BufferbloatGrade})` : '-';
+            row.insertCell().textContent = res.ShapingPattern ? `${res.ShapingPattern === 'stalls' ? `停顿 ${res.Stalls} 次` : '突发后限速'} (${(res.BurstSpeed / 1024).toFixed(2)} → ${(res.SustainedSpeed / 1024).toFixed(2)})` : '-';
+            row.insertCell().textContent = res.DataUsage ? formatBytes(res.DataUsage) : '-';
+            row.insertCell().textContent = res.QUICDelay ? `${(res.QUICDelay / 1000000).toFixed(2)} ms / ${(res.QUICDownloadSpeed / 1024).toFixed(2)} MB/s` : '-';
             row.insertCell().textContent = res.Colo;
             row.insertCell().textContent = res.Region;
+            row.insertCell().textContent = res.Prefix ? `${res.Prefix} (AS${res.OriginASN})` : '-';
             
             const actionCell = row.insertCell();
             const copyBtn = document.createElement('button');
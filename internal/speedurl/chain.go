@@ -0,0 +1,201 @@
+package speedurl
+
+import (
+	"sync"
+	"time"
+)
+
+// recheckInterval 是所有地址都不可用时重新检查的最小间隔
+const recheckInterval = 30 * time.Second
+
+// Stats 是单个测速地址的使用统计
+type Stats struct {
+	URL            string
+	Healthy        bool
+	Attempts       int     // 使用该地址进行的测速次数
+	Successes      int     // 成功的测速次数
+	StatusFailures int     // 返回非预期状态码或被重定向到 HTTPS 的次数
+	Errors         int     // 超时、连接失败等其他错误的次数
+	TotalSpeed     float64 // 成功测速的速度之和（B/s）
+}
+
+// AverageSpeed 返回成功测速的平均速度（B/s）
+func (s Stats) AverageSpeed() float64 {
+	if s.Successes == 0 {
+		return 0
+	}
+	return s.TotalSpeed / float64(s.Successes)
+}
+
+type entry struct {
+	Stats
+	consecutiveFailures int  // 连续的状态码失败次数
+	checking            bool // 正在复查该地址
+}
+
+// Chain 按配置顺序管理一组测速地址，始终优先使用排在前面的健康地址。
+// 只有地址本身引起的失败（状态码或重定向）计入连续失败次数；达到阈值后先复查地址，复查也失败才标记为不可用。
+// 所有方法都是并发安全的。
+type Chain struct {
+	mu          sync.Mutex
+	entries     []*entry
+	maxFailures int
+	check       func(url string) error
+	lastRecheck time.Time
+}
+
+// New 创建测速地址链，maxFailures 为标记不可用前允许的连续失败次数，check 用于检查单个地址是否可用
+func New(urls []string, maxFailures int, check func(url string) error) *Chain {
+	c := &Chain{maxFailures: max(maxFailures, 1), check: check}
+	for _, u := range urls {
+		c.entries = append(c.entries, &entry{Stats: Stats{URL: u, Healthy: true}})
+	}
+	return c
+}
+
+// CheckHealth 依次检查每个地址，检查失败的地址被标记为不可用，返回各地址的检查错误
+func (c *Chain) CheckHealth() map[string]error {
+	failures := make(map[string]error)
+	for _, e := range c.entries {
+		err := c.check(e.URL)
+		c.mu.Lock()
+		e.Healthy = err == nil
+		c.mu.Unlock()
+		if err != nil {
+			failures[e.URL] = err
+		}
+	}
+	return failures
+}
+
+// Current 返回第一个健康的地址。所有地址都不可用时返回第一个地址，并且第二个返回值为 false
+func (c *Chain) Current() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if e.Healthy {
+			return e.URL, true
+		}
+	}
+	if len(c.entries) == 0 {
+		return "", false
+	}
+	return c.entries[0].URL, false
+}
+
+// Next 返回排在 url 之后的下一个健康地址，没有时返回 false
+func (c *Chain) Next(url string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := false
+	for _, e := range c.entries {
+		if found && e.Healthy {
+			return e.URL, true
+		}
+		if e.URL == url {
+			found = true
+		}
+	}
+	return "", false
+}
+
+// RecordSuccess 记录一次成功的测速，并清零该地址的连续失败次数
+func (c *Chain) RecordSuccess(url string, speed float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.find(url); e != nil {
+		e.Attempts++
+		e.Successes++
+		e.TotalSpeed += speed
+		e.consecutiveFailures = 0
+	}
+}
+
+// RecordFailure 记录一次失败的测速，statusFailure 表示失败由地址本身（状态码或重定向）引起。
+// 超时、连接失败等错误与 IP 有关，只计入统计。连续的状态码失败达到阈值时复查该地址，
+// 返回该地址是否因复查失败被标记为不可用。
+func (c *Chain) RecordFailure(url string, statusFailure bool) bool {
+	c.mu.Lock()
+	e := c.find(url)
+	if e == nil {
+		c.mu.Unlock()
+		return false
+	}
+	e.Attempts++
+	if !statusFailure {
+		e.Errors++
+		c.mu.Unlock()
+		return false
+	}
+	e.StatusFailures++
+	e.consecutiveFailures++
+	if !e.Healthy || e.checking || e.consecutiveFailures < c.maxFailures {
+		c.mu.Unlock()
+		return false
+	}
+	e.checking = true
+	c.mu.Unlock()
+
+	err := c.check(url)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.checking = false
+	e.consecutiveFailures = 0
+	if err == nil {
+		return false // 地址本身可用，失败来自个别 IP
+	}
+	e.Healthy = false
+	return true
+}
+
+// Recheck 在所有地址都不可用时重新检查它们，检查通过的地址恢复为可用，返回恢复的地址。
+// 两次检查至少间隔 recheckInterval，避免每个 IP 都触发一轮检查。
+func (c *Chain) Recheck() []string {
+	c.mu.Lock()
+	var pending []*entry
+	if time.Since(c.lastRecheck) >= recheckInterval {
+		for _, e := range c.entries {
+			if e.Healthy {
+				c.mu.Unlock()
+				return nil
+			}
+			pending = append(pending, e)
+		}
+		c.lastRecheck = time.Now()
+	}
+	c.mu.Unlock()
+
+	var restored []string
+	for _, e := range pending {
+		if c.check(e.URL) != nil {
+			continue
+		}
+		c.mu.Lock()
+		e.Healthy = true
+		e.consecutiveFailures = 0
+		c.mu.Unlock()
+		restored = append(restored, e.URL)
+	}
+	return restored
+}
+
+// Stats 返回所有地址的统计，顺序与配置一致
+func (c *Chain) Stats() []Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make([]Stats, len(c.entries))
+	for i, e := range c.entries {
+		stats[i] = e.Stats
+	}
+	return stats
+}
+
+func (c *Chain) find(url string) *entry {
+	for _, e := range c.entries {
+		if e.URL == url {
+			return e
+		}
+	}
+	return nil
+}
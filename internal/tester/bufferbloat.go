@@ -0,0 +1,151 @@
+package tester
+
+import (
+	"Domain_IP_Selector_Go/internal/usage"
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// idleProbeCount 是下载开始前测量空载延迟的握手次数
+	idleProbeCount = 3
+	// loadedProbeTimeout 是负载期间单次握手的超时时间，超时的探测不计入样本
+	loadedProbeTimeout = 2 * time.Second
+)
+
+var (
+	// ErrNoIdleSamples 表示下载前的空载延迟探测全部失败
+	ErrNoIdleSamples = errors.New("空载延迟探测全部失败")
+	// ErrNoLoadedSamples 表示负载期间没有任何一次延迟探测成功
+	ErrNoLoadedSamples = errors.New("负载期间没有成功的延迟探测")
+)
+
+// bufferbloatGrades 是各等级允许的最大延迟增量，按从好到差排列，超过最后一档为 F
+var bufferbloatGrades = []struct {
+	grade string
+	limit time.Duration
+}{
+	{"A+", 5 * time.Millisecond},
+	{"A", 30 * time.Millisecond},
+	{"B", 60 * time.Millisecond},
+	{"C", 200 * time.Millisecond},
+	{"D", 400 * time.Millisecond},
+}
+
+// LoadedLatencyResult 包含下载期间的负载延迟测量结果
+type LoadedLatencyResult struct {
+	IdleDelay   time.Duration // 下载前 TCP 握手延迟的中位数
+	LoadedDelay time.Duration // 下载期间 TCP 握手延迟的中位数
+	Samples     int           // 下载期间成功的探测次数
+}
+
+// Increase 返回负载延迟相对空载延迟的增量，不会小于 0
+func (r LoadedLatencyResult) Increase() time.Duration {
+	return max(r.LoadedDelay-r.IdleDelay, 0)
+}
+
+// Grade 返回延迟增量对应的缓冲膨胀等级（A+、A、B、C、D、F）
+func (r LoadedLatencyResult) Grade() string {
+	return BufferbloatGrade(r.Increase())
+}
+
+// BufferbloatGrade 将负载下的延迟增量换算为等级
+func BufferbloatGrade(increase time.Duration) string {
+	for _, g := range bufferbloatGrades {
+		if increase < g.limit {
+			return g.grade
+		}
+	}
+	return "F"
+}
+
+// BufferbloatGradeRank 返回等级的序号，越小越好，未知等级返回 false
+func BufferbloatGradeRank(grade string) (int, bool) {
+	for i, g := range bufferbloatGrades {
+		if g.grade == grade {
+			return i, true
+		}
+	}
+	if grade == "F" {
+		return len(bufferbloatGrades), true
+	}
+	return 0, false
+}
+
+// TestLatencyUnderLoad 先测量 IP 的空载 TCP 握手延迟，然后在执行 load（通常是对同一 IP 的下载测速）期间
+// 每隔 interval 进行一次握手，得到负载延迟。探测失败时 load 仍会执行，返回的错误只反映探测结果。
+func (s *Session) TestLatencyUnderLoad(ip *net.IPAddr, port int, interval time.Duration, load func()) (*LoadedLatencyResult, error) {
+	res := &LoadedLatencyResult{}
+
+	idle := probeHandshakes(context.Background(), ip, port, 0, idleProbeCount, s.meter())
+	if len(idle) == 0 {
+		load()
+		return nil, ErrNoIdleSamples
+	}
+	res.IdleDelay = medianDuration(idle)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		wg     sync.WaitGroup
+		loaded []time.Duration
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		loaded = probeHandshakes(ctx, ip, port, interval, 0, s.meter())
+	}()
+	load()
+	cancel()
+	wg.Wait()
+
+	if len(loaded) == 0 {
+		return nil, ErrNoLoadedSamples
+	}
+	res.LoadedDelay = medianDuration(loaded)
+	res.Samples = len(loaded)
+	return res, nil
+}
+
+// probeHandshakes 每隔 interval（为 0 时连续进行）进行一次 TCP 握手并返回成功的耗时，
+// 在 ctx 结束或完成 count 次探测（count 为 0 时不限次数）后返回
+func probeHandshakes(ctx context.Context, ip *net.IPAddr, port int, interval time.Duration, count int, meter *usage.Meter) []time.Duration {
+	var samples []time.Duration
+	for i := 0; count == 0 || i < count; i++ {
+		if interval > 0 {
+			select {
+			case <-ctx.Done():
+				return samples
+			case <-time.After(interval):
+			}
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, loadedProbeTimeout)
+		start := time.Now()
+		conn, err := getDialContextTimeout(ip, port, loadedProbeTimeout, meter)(probeCtx, "tcp", "")
+		elapsed := time.Since(start)
+		cancel()
+		if ctx.Err() != nil {
+			// 负载结束时被中断的探测不计入样本
+			if err == nil {
+				conn.Close()
+			}
+			return samples
+		}
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		samples = append(samples, elapsed)
+	}
+	return samples
+}
+
+// medianDuration 返回样本的中位数，samples 不能为空
+func medianDuration(samples []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return percentile(sorted, 0.5)
+}
@@ -0,0 +1,49 @@
+package tester
+
+import (
+	"time"
+)
+
+// convergenceSlices 是判断速度收敛时比较的时间片数
+const convergenceSlices = 10
+
+// DurationPolicy 控制下载测速的时长：至少测速 MinDuration，之后在速度估计收敛时提前停止，最多测速 MaxDuration。
+// 时间片长度固定为 MaxDuration 的 1/100，提前停止不影响速度的计算方式。
+type DurationPolicy struct {
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	Tolerance   float64 // 最近 10 个时间片内速度估计的波动不超过该比例时停止，0 表示始终测满 MaxDuration
+}
+
+// FixedDuration 返回固定测速 d 的时长策略
+func FixedDuration(d time.Duration) DurationPolicy {
+	return DurationPolicy{MinDuration: d, MaxDuration: d}
+}
+
+// convergenceTracker 记录每个时间片结束时的速度估计，用于判断是否可以提前停止
+type convergenceTracker struct {
+	policy    DurationPolicy
+	estimates []float64
+}
+
+func (p DurationPolicy) newTracker() *convergenceTracker {
+	return &convergenceTracker{policy: p}
+}
+
+// add 记录一个时间片结束时的速度估计，elapsed 为已测速时长，返回是否已经收敛
+func (t *convergenceTracker) add(estimate float64, elapsed time.Duration) bool {
+	if t.policy.Tolerance <= 0 {
+		return false
+	}
+	t.estimates = append(t.estimates, estimate)
+	if elapsed < t.policy.MinDuration || len(t.estimates) < convergenceSlices {
+		return false
+	}
+	window := t.estimates[len(t.estimates)-convergenceSlices:]
+	low, high := window[0], window[0]
+	for _, v := range window[1:] {
+		low = min(low, v)
+		high = max(high, v)
+	}
+	return high > 0 && (high-low)/high <= t.policy.Tolerance
+}
@@ -0,0 +1,232 @@
+package tester
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// 可选的浏览器 TLS 指纹
+const (
+	FingerprintChrome  = "chrome"
+	FingerprintFirefox = "firefox"
+	FingerprintSafari  = "safari"
+)
+
+// fingerprintIDs 是各浏览器指纹对应的 ClientHello，使用 utls 内置的最新版本
+var fingerprintIDs = map[string]utls.ClientHelloID{
+	FingerprintChrome:  utls.HelloChrome_Auto,
+	FingerprintFirefox: utls.HelloFirefox_Auto,
+	FingerprintSafari:  utls.HelloSafari_Auto,
+}
+
+// Fingerprints 返回所有可选的浏览器 TLS 指纹名称
+func Fingerprints() []string {
+	names := make([]string, 0, len(fingerprintIDs))
+	for name := range fingerprintIDs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateFingerprint 检查浏览器 TLS 指纹名称是否有效，空字符串表示使用 Go 默认的 TLS 握手
+func ValidateFingerprint(name string) error {
+	if _, ok := fingerprintIDs[name]; !ok && name != "" {
+		return fmt.Errorf("不支持的 TLS 指纹 %q，可选值为 %v", name, Fingerprints())
+	}
+	return nil
+}
+
+// newTransport 使用给定的拨号函数创建 HTTP 传输层。fingerprint 不为空时由 utls 以对应浏览器的 ClientHello 完成 TLS 握手，
+// 并按服务器协商的协议使用 HTTP/2 或 HTTP/1.1。
+func newTransport(dialContext func(ctx context.Context, network, address string) (net.Conn, error), fingerprint string) http.RoundTripper {
+	id, ok := fingerprintIDs[fingerprint]
+	if !ok {
+		return &http.Transport{DialContext: dialContext}
+	}
+	t := &fingerprintTransport{
+		dialTLS: func(ctx context.Context, network, address string) (*utls.UConn, error) {
+			conn, err := dialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			serverName, _, err := net.SplitHostPort(address)
+			if err != nil {
+				serverName = address
+			}
+			return tracedHandshake(ctx, conn, serverName, id)
+		},
+		probes:  make(map[string]*protocolProbe),
+		pending: make(map[string]net.Conn),
+	}
+	t.h1 = &http.Transport{
+		DialContext: dialContext,
+		DialTLSContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return t.dial(ctx, network, address)
+		},
+	}
+	t.h2 = &http2.Transport{
+		DialTLSContext: func(ctx context.Context, network, address string, _ *tls.Config) (net.Conn, error) {
+			return t.dial(ctx, network, address)
+		},
+	}
+	return t
+}
+
+// fingerprintTransport 以浏览器的 ClientHello（包括其 ALPN）完成 TLS 握手。http.Transport 只能识别 crypto/tls 连接上协商的 HTTP/2，
+// 因此每个地址先拨出一个连接确定协议，之后的请求交给对应的传输层，这个连接留给传输层的第一次拨号使用。
+// 同一地址的并发请求只拨出一个连接确定协议，其余请求等待结果；协商到 HTTP/2 时这些请求与浏览器一样复用同一个连接。
+type fingerprintTransport struct {
+	dialTLS func(ctx context.Context, network, address string) (*utls.UConn, error)
+	h1      *http.Transport
+	h2      *http2.Transport
+
+	mu      sync.Mutex
+	probes  map[string]*protocolProbe // 各地址正在进行或已完成的协议探测
+	pending map[string]net.Conn       // 确定协议时拨出、尚未交给传输层的连接
+}
+
+// protocolProbe 是对一个地址的协议探测，done 关闭后 protocol 和 err 可读
+type protocolProbe struct {
+	done     chan struct{}
+	protocol string
+	err      error
+}
+
+func (t *fingerprintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.h1.RoundTrip(req)
+	}
+	port := req.URL.Port()
+	if port == "" {
+		port = "443"
+	}
+	address := net.JoinHostPort(req.URL.Hostname(), port)
+
+	protocol, err := t.probe(req.Context(), address)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == http2.NextProtoTLS {
+		return t.h2.RoundTrip(req)
+	}
+	return t.h1.RoundTrip(req)
+}
+
+// probe 返回地址协商的 ALPN 协议。同一地址只有一个请求拨出连接进行探测，并发的请求等待其结果；
+// 探测失败时不缓存结果，等待的请求会重新探测。
+func (t *fingerprintTransport) probe(ctx context.Context, address string) (string, error) {
+	for {
+		t.mu.Lock()
+		p, ok := t.probes[address]
+		if !ok {
+			p = &protocolProbe{done: make(chan struct{})}
+			t.probes[address] = p
+		}
+		t.mu.Unlock()
+
+		if !ok {
+			conn, err := t.dialTLS(ctx, "tcp", address)
+			t.mu.Lock()
+			if err != nil {
+				p.err = err
+				delete(t.probes, address)
+			} else {
+				p.protocol = conn.ConnectionState().NegotiatedProtocol
+				t.pending[address] = conn
+			}
+			t.mu.Unlock()
+			close(p.done)
+			return p.protocol, p.err
+		}
+
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if p.err == nil {
+			return p.protocol, nil
+		}
+	}
+}
+
+// dial 优先返回确定协议时拨出的连接，没有时拨出新的连接
+func (t *fingerprintTransport) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	t.mu.Lock()
+	if conn, ok := t.pending[address]; ok {
+		delete(t.pending, address)
+		t.mu.Unlock()
+		return conn, nil
+	}
+	t.mu.Unlock()
+	return t.dialTLS(ctx, network, address)
+}
+
+// CloseIdleConnections 关闭两个传输层的空闲连接，以及确定协议时拨出、未被使用的连接
+func (t *fingerprintTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	for address, conn := range t.pending {
+		conn.Close()
+		delete(t.pending, address)
+	}
+	t.mu.Unlock()
+	t.h1.CloseIdleConnections()
+	t.h2.CloseIdleConnections()
+}
+
+// tracedHandshake 完成 utls 握手，并像 http.Transport 自己握手时一样触发 httptrace 的 TLS 回调，
+// 使延迟测试仍能记录 TLS 握手耗时
+func tracedHandshake(ctx context.Context, conn net.Conn, serverName string, id utls.ClientHelloID) (*utls.UConn, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	uconn, err := fingerprintHandshake(ctx, conn, serverName, id)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		var state tls.ConnectionState
+		if err == nil {
+			s := uconn.ConnectionState()
+			state = tls.ConnectionState{
+				Version:            s.Version,
+				HandshakeComplete:  s.HandshakeComplete,
+				CipherSuite:        s.CipherSuite,
+				NegotiatedProtocol: s.NegotiatedProtocol,
+				ServerName:         s.ServerName,
+			}
+		}
+		trace.TLSHandshakeDone(state, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return uconn, nil
+}
+
+// fingerprintHandshake 在 conn 上以浏览器的 ClientHello 完成 TLS 握手，失败时关闭 conn
+func fingerprintHandshake(ctx context.Context, conn net.Conn, serverName string, id utls.ClientHelloID) (*utls.UConn, error) {
+	uconn := utls.UClient(conn, &utls.Config{ServerName: serverName}, id)
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return uconn, nil
+}
+
+// clientHandshake 在 conn 上完成 TLS 握手，fingerprint 不为空时模拟对应的浏览器
+func clientHandshake(ctx context.Context, conn net.Conn, serverName, fingerprint string) error {
+	if id, ok := fingerprintIDs[fingerprint]; ok {
+		_, err := fingerprintHandshake(ctx, conn, serverName, id)
+		return err
+	}
+	return tls.Client(conn, &tls.Config{ServerName: serverName}).HandshakeContext(ctx)
+}
@@ -0,0 +1,99 @@
+package tester
+
+import (
+	"Domain_IP_Selector_Go/internal/usage"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// quicDialer 将所有 QUIC 连接指向给定 IP，并记录最近一次握手的耗时
+type quicDialer struct {
+	target    string
+	meter     *usage.Meter
+	mu        sync.Mutex
+	handshake time.Duration
+	conns     []*quic.Conn
+}
+
+func newQUICDialer(ip *net.IPAddr, port int, meter *usage.Meter) *quicDialer {
+	return &quicDialer{target: net.JoinHostPort(ip.IP.String(), strconv.Itoa(port)), meter: meter}
+}
+
+func (d *quicDialer) dial(ctx context.Context, _ string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+	start := time.Now()
+	conn, err := quic.DialAddr(ctx, d.target, tlsCfg, cfg)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.handshake = time.Since(start)
+	d.conns = append(d.conns, conn)
+	d.mu.Unlock()
+	return conn, nil
+}
+
+// recordUsage 将拨出的 QUIC 连接的流量计入流量统计，需要在关闭连接前调用
+func (d *quicDialer) recordUsage() {
+	host, _, _ := net.SplitHostPort(d.target)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, conn := range d.conns {
+		stats := conn.ConnectionStats()
+		d.meter.Add(host, int64(stats.BytesSent), int64(stats.BytesReceived))
+	}
+	d.conns = nil
+}
+
+func (d *quicDialer) lastHandshake() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.handshake
+}
+
+// TestLatencyHTTP3 通过 HTTP/3（QUIC）进行 HTTPing，请求次数由采样策略决定，结果中的 TLSTime 为 QUIC 握手耗时
+func (s *Session) TestLatencyHTTP3(ip *net.IPAddr, port int, testURL string, policy SamplingPolicy, probe Probe) (*HttpingResult, error) {
+	dialer := newQUICDialer(ip, port, s.meter())
+	transport := &http3.Transport{Dial: dialer.dial}
+	defer transport.Close()
+	defer dialer.recordUsage()
+
+	hc := &http.Client{
+		Timeout:   probe.ClientTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse // 阻止重定向
+		},
+	}
+	res, err := httping(hc, testURL, policy, probe)
+	if err != nil {
+		return nil, err
+	}
+	// QUIC 不经过 net.Dialer 与 crypto/tls 的握手流程，httptrace 无法记录，这里用拨号耗时补上
+	if res.Stats.TLSTime == 0 {
+		res.Stats.TLSTime = dialer.lastHandshake()
+	}
+	return res, nil
+}
+
+// TestDownloadSpeedHTTP3 通过 HTTP/3（QUIC）对单个 IP 进行下载速度测试，streams 个下载复用同一个 QUIC 连接
+func (s *Session) TestDownloadSpeedHTTP3(ip *net.IPAddr, port int, testURL string, duration DurationPolicy, rateLimitMB float64, streams int) (*SpeedTestResult, error) {
+	finalURL := "https://cf.xiu2.xyz/url"
+	if testURL != "" {
+		finalURL = testURL
+	}
+
+	dialer := newQUICDialer(ip, port, s.meter())
+	transport := &http3.Transport{Dial: dialer.dial}
+	defer transport.Close()
+	defer dialer.recordUsage()
+
+	return parallelDownload(newSpeedTestClient(transport, duration.MaxDuration), finalURL, duration, rateLimitMB, streams)
+}
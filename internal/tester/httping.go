@@ -1,44 +1,64 @@
 package tester
 
 import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	//"crypto/tls"
-	//"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"time"
 )
 
+// ErrInvalidStatus 表示服务器返回了不被接受的 HTTP 状态码
+var ErrInvalidStatus = errors.New("invalid status code")
+
 // HttpingResult 包含一次 HTTPing 测试的结果
 type HttpingResult struct {
-	Delay    time.Duration
+	Delay    time.Duration // 平均总耗时
 	LossRate float64
 	Colo     string
+	Trace    model.TraceInfo
+	Stats    model.LatencyStats
+	TCPInfo  model.TCPInfo // 仅 Linux 上的 TCP 测试有数据
 }
 
-// TestLatency 通过 HTTPing 测试单个 IP 的延迟
-func TestLatency(ip *net.IPAddr, testURL string, pingTimes int) (*HttpingResult, error) {
-	hc := http.Client{
-		Timeout: time.Second * 2,
-		Transport: &http.Transport{
-			DialContext: getDialContext(ip, DefaultTCPPort),
-			//TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // 跳过证书验证
-		},
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse // 阻止重定向
-		},
+// TestLatency 使用默认参数通过 HTTPing 测试单个 IP 在指定端口上的延迟，固定发送 pingTimes 次请求
+func (s *Session) TestLatency(ip *net.IPAddr, port int, testURL string, pingTimes int) (*HttpingResult, error) {
+	return s.TestLatencyAdaptive(ip, port, testURL, FixedSampling(pingTimes), DefaultProbe)
+}
+
+// TestLatencyAdaptive 通过 HTTPing 测试单个 IP 的延迟，请求次数由采样策略动态决定
+func (s *Session) TestLatencyAdaptive(ip *net.IPAddr, port int, testURL string, policy SamplingPolicy, probe Probe) (*HttpingResult, error) {
+	recorder := newTCPInfoRecorder()
+	res, err := httping(newLatencyClient(recorder.dialContext(ip, port, probe.DialTimeout, s.meter()), probe.ClientTimeout, s.fingerprint()), testURL, policy, probe)
+	if err != nil {
+		return nil, err
 	}
+	res.TCPInfo = recorder.snapshot()
+	return res, nil
+}
+
+// httping 使用给定的客户端执行 HTTPing，客户端决定了底层使用的协议（TCP 或 QUIC）
+func httping(hc *http.Client, testURL string, policy SamplingPolicy, probe Probe) (*HttpingResult, error) {
 
-	// 先访问一次获得 HTTP 状态码 及 Cloudflare Colo
-	var colo string
+	// 先用 GET 访问一次获得 HTTP 状态码、Cloudflare Colo 及 trace 信息
+	var (
+		colo  string
+		trace model.TraceInfo
+		setup LatencySample
+	)
 	{
-		request, err := http.NewRequest(http.MethodHead, testURL, nil)
+		request, err := http.NewRequest(http.MethodGet, testURL, nil)
 		if err != nil {
 			return nil, err
 		}
-		request.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.80 Safari/537.36")
+		request = withSampleTrace(request, &setup)
+		request.Header.Set("User-Agent", probe.UserAgent)
 		response, err := hc.Do(request)
 		if err != nil {
 			return nil, err
@@ -46,29 +66,31 @@ func TestLatency(ip *net.IPAddr, testURL string, pingTimes int) (*HttpingResult,
 		defer response.Body.Close()
 
 		// 默认只认为 200, 301, 302 才算 HTTPing 通过
-		if response.StatusCode != 200 && response.StatusCode != 301 && response.StatusCode != 302 {
-			return nil, fmt.Errorf("invalid status code: %d", response.StatusCode)
+		if !probe.accepts(response.StatusCode) {
+			return nil, fmt.Errorf("%w: %d", ErrInvalidStatus, response.StatusCode)
 		}
 
-		io.Copy(io.Discard, response.Body)
-
-		// 通过头部 Server 值判断是 Cloudflare 还是 AWS CloudFront 并设置 cfRay 为各自的机场地区码完整内容
-		colo = getHeaderColo(response.Header)
+		// 优先使用 trace 响应体中的 colo，非 trace 地址时通过响应头判断
+		colo, trace = responseTrace(response)
 	}
 
-	// 循环测速计算延迟
-	success := 0
-	var totalDelay time.Duration
-	for i := 0; i < pingTimes; i++ {
-		request, err := http.NewRequest(http.MethodHead, testURL, nil)
+	// 按采样策略循环测速计算延迟
+	defer hc.CloseIdleConnections()
+	success, attempts := 0, 0
+	var (
+		totalDelay time.Duration
+		samples    []LatencySample
+		totals     []time.Duration
+	)
+	for ; !policy.done(totals, attempts); attempts++ {
+		var sample LatencySample
+		request, err := http.NewRequest(probe.Method, testURL, nil)
 		if err != nil {
 			log.Printf("创建请求失败: %v", err) // 使用 log 记录非致命错误
 			continue
 		}
-		request.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.80 Safari/537.36")
-		if i == pingTimes-1 {
-			request.Header.Set("Connection", "close")
-		}
+		request.Header.Set("User-Agent", probe.UserAgent)
+		request = withSampleTrace(request, &sample)
 		startTime := time.Now()
 		response, err := hc.Do(request)
 		if err != nil {
@@ -79,6 +101,9 @@ func TestLatency(ip *net.IPAddr, testURL string, pingTimes int) (*HttpingResult,
 		_ = response.Body.Close()
 		duration := time.Since(startTime)
 		totalDelay += duration
+		sample.Total = duration
+		samples = append(samples, sample)
+		totals = append(totals, duration)
 	}
 
 	if success == 0 {
@@ -87,9 +112,52 @@ func TestLatency(ip *net.IPAddr, testURL string, pingTimes int) (*HttpingResult,
 
 	result := &HttpingResult{
 		Delay:    totalDelay / time.Duration(success),
-		LossRate: float64(pingTimes-success) / float64(pingTimes),
+		LossRate: float64(attempts-success) / float64(attempts),
 		Colo:     colo,
+		Trace:    trace,
+		Stats:    summarizeSamples(samples, setup),
 	}
 
 	return result, nil
 }
+
+// newLatencyClient 使用给定的拨号函数、超时与 TLS 指纹创建用于延迟测试的 HTTP 客户端
+func newLatencyClient(dialContext func(ctx context.Context, network, address string) (net.Conn, error), timeout time.Duration, fingerprint string) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: newTransport(dialContext, fingerprint),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse // 阻止重定向
+		},
+	}
+}
+
+// withSampleTrace 为请求挂载 httptrace，将连接、TLS 握手与首字节耗时记录到 sample 中
+func withSampleTrace(request *http.Request, sample *LatencySample) *http.Request {
+	var requestStart, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			requestStart = time.Now()
+		},
+		ConnectStart: func(string, string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				sample.Connect = time.Since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				sample.TLS = time.Since(tlsStart)
+			}
+		},
+		GotFirstResponseByte: func() {
+			sample.TTFB = time.Since(requestStart)
+		},
+	}
+	return request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+}
@@ -0,0 +1,34 @@
+package tester
+
+import (
+	"net/http"
+	"time"
+)
+
+// Probe 描述延迟测试请求的参数
+type Probe struct {
+	ClientTimeout  time.Duration // 单次请求的总超时
+	DialTimeout    time.Duration // 建立 TCP 连接的超时
+	Method         string        // 计时请求使用的 HTTP 方法，首个获取 trace 的请求始终使用 GET
+	UserAgent      string
+	AcceptedStatus []int // 视为通过的 HTTP 状态码
+}
+
+// DefaultProbe 是未配置 latency_profile 时使用的参数
+var DefaultProbe = Probe{
+	ClientTimeout:  2 * time.Second,
+	DialTimeout:    2 * time.Second,
+	Method:         http.MethodHead,
+	UserAgent:      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.80 Safari/537.36",
+	AcceptedStatus: []int{200, 301, 302},
+}
+
+// accepts 判断状态码是否视为通过
+func (p Probe) accepts(statusCode int) bool {
+	for _, code := range p.AcceptedStatus {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
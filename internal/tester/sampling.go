@@ -0,0 +1,79 @@
+package tester
+
+import (
+	"math"
+	"time"
+)
+
+// tTable95 是双侧 95% 置信度下自由度 1~30 的 t 分布临界值，更大的自由度使用正态近似 1.96
+var tTable95 = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// SamplingPolicy 控制 HTTPing 的顺序采样：至少发送 MinSamples 次请求，
+// 之后在均值的置信区间足够窄、或明显高于 MaxDelay 时提前停止，最多发送 MaxSamples 次
+type SamplingPolicy struct {
+	MinSamples int
+	MaxSamples int
+	MaxDelay   time.Duration // 置信区间下限超过该值时提前停止，0 表示不启用
+	Precision  float64       // 置信区间半宽不超过均值的该比例时停止，0 表示始终采满 MaxSamples
+}
+
+// FixedSampling 返回固定发送 n 次请求的采样策略
+func FixedSampling(n int) SamplingPolicy {
+	return SamplingPolicy{MinSamples: n, MaxSamples: n}
+}
+
+// done 根据已完成的请求次数与成功样本的总耗时判断是否停止采样
+func (p SamplingPolicy) done(totals []time.Duration, attempts int) bool {
+	minSamples := max(p.MinSamples, 1)
+	if attempts >= max(p.MaxSamples, minSamples) {
+		return true
+	}
+	if attempts < minSamples {
+		return false
+	}
+	if len(totals) == 0 {
+		return true // 最少次数内全部失败，不再继续
+	}
+	if len(totals) < 2 {
+		return false
+	}
+	low, high := confidenceInterval(totals)
+	if p.MaxDelay > 0 && low > p.MaxDelay {
+		return true
+	}
+	mean := (low + high) / 2
+	return p.Precision > 0 && float64(high-low)/2 <= p.Precision*float64(mean)
+}
+
+// confidenceInterval 计算样本均值的 95% 置信区间，样本少于 2 个时区间退化为均值本身
+func confidenceInterval(totals []time.Duration) (low, high time.Duration) {
+	n := len(totals)
+	if n == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, d := range totals {
+		sum += float64(d)
+	}
+	mean := sum / float64(n)
+	if n < 2 {
+		return time.Duration(mean), time.Duration(mean)
+	}
+
+	var variance float64
+	for _, d := range totals {
+		variance += (float64(d) - mean) * (float64(d) - mean)
+	}
+	variance /= float64(n - 1)
+
+	t := 1.96
+	if n-1 <= len(tTable95) {
+		t = tTable95[n-2]
+	}
+	half := t * math.Sqrt(variance/float64(n))
+	return time.Duration(mean - half), time.Duration(mean + half)
+}
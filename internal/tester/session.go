@@ -0,0 +1,26 @@
+package tester
+
+import "Domain_IP_Selector_Go/internal/usage"
+
+// Session 保存一次运行中所有测试共用的设置。每次运行使用各自的 Session，多个运行可以同时进行。
+// nil Session 使用默认设置。
+type Session struct {
+	Meter       *usage.Meter // 统计测试连接的流量，nil 时不统计
+	Fingerprint string       // TCP 测试在 TLS 握手时模拟的浏览器（见 Fingerprints），为空时使用 Go 默认的 TLS 握手，HTTP/3 测试不受影响
+}
+
+// meter 返回流量统计，未设置时返回 nil
+func (s *Session) meter() *usage.Meter {
+	if s == nil {
+		return nil
+	}
+	return s.Meter
+}
+
+// fingerprint 返回 TLS 指纹名称，未设置时返回空字符串
+func (s *Session) fingerprint() string {
+	if s == nil {
+		return ""
+	}
+	return s.Fingerprint
+}
@@ -0,0 +1,83 @@
+package tester
+
+import (
+	"sort"
+)
+
+// 吞吐量曲线的异常形态
+const (
+	ShapingBurstThrottle = "burst-throttle" // 开始时高速突发，随后被限速
+	ShapingStalls        = "stalls"         // 传输过程中多次停顿
+)
+
+const (
+	// burstWindow 是计算突发速度时滑动窗口包含的时间片数
+	burstWindow = 5
+	// slowStartSlices 是开头不参与停顿判断的时间片数，避免把连接建立与慢启动算作停顿
+	slowStartSlices = 5
+	// stallFraction 是停顿的判定阈值，吞吐量低于曲线中位数的该比例视为停顿
+	stallFraction = 0.1
+	// minStalls 是判定为周期性停顿所需的最少停顿次数
+	minStalls = 3
+	// minCurvePoints 是进行形态分析所需的最少时间片数
+	minCurvePoints = 20
+)
+
+// ShapingReport 描述一次下载测速的吞吐量曲线形态
+type ShapingReport struct {
+	BurstSpeed     float64 // 前 30% 时间内滑动窗口的最高平均吞吐量（B/s）
+	SustainedSpeed float64 // 后一半时间的平均吞吐量（B/s）
+	Stalls         int     // 吞吐量跌至接近 0 的停顿次数
+	Pattern        string  // 检测到的异常形态，正常时为空
+}
+
+// AnalyzeThroughput 分析每个时间片的吞吐量曲线。持续速度低于突发速度的 throttleRatio 倍时判定为突发后限速，
+// throttleRatio 不大于 0 时不做该判断。曲线过短时返回空结果。
+func AnalyzeThroughput(curve []float64, throttleRatio float64) ShapingReport {
+	var report ShapingReport
+	// 最后一个时间片通常不完整，不参与分析
+	if len(curve) > 0 {
+		curve = curve[:len(curve)-1]
+	}
+	if len(curve) < minCurvePoints {
+		return report
+	}
+
+	burstEnd := len(curve) * 3 / 10
+	for i := 0; i+burstWindow <= burstEnd; i++ {
+		report.BurstSpeed = max(report.BurstSpeed, mean(curve[i:i+burstWindow]))
+	}
+	report.SustainedSpeed = mean(curve[len(curve)/2:])
+
+	sorted := append([]float64(nil), curve...)
+	sort.Float64s(sorted)
+	if threshold := sorted[len(sorted)/2] * stallFraction; threshold > 0 {
+		stalled := false
+		for _, v := range curve[slowStartSlices:] {
+			if v < threshold && !stalled {
+				report.Stalls++
+			}
+			stalled = v < threshold
+		}
+	}
+
+	switch {
+	case throttleRatio > 0 && report.BurstSpeed > 0 && report.SustainedSpeed < report.BurstSpeed*throttleRatio:
+		report.Pattern = ShapingBurstThrottle
+	case report.Stalls >= minStalls:
+		report.Pattern = ShapingStalls
+	}
+	return report
+}
+
+// mean 返回样本的平均值
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -1,11 +1,14 @@
 package tester
 
 import (
+	"Domain_IP_Selector_Go/pkg/model"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/VividCortex/ewma"
@@ -15,56 +18,173 @@ import (
 const (
 	// 使用更大的缓冲区来提高单线程下载效率
 	BufferSize = 8192
+	// ThroughputSlices 是下载测速划分的时间片数量，吞吐量曲线每个时间片一个点
+	ThroughputSlices = 100
 )
 
+// ErrHTTPSRedirect 表示明文 HTTP 测速地址被重定向到了 HTTPS
+var ErrHTTPSRedirect = errors.New("明文 HTTP 地址被重定向到 HTTPS")
+
 // SpeedTestResult 包含一次下载速度测试的结果
 type SpeedTestResult struct {
-	DownloadSpeed float64 // in B/s
+	DownloadSpeed float64   // in B/s，多连接测速时为各连接之和
+	StreamSpeeds  []float64 // 每个连接的下载速度（B/s），失败的连接为 0
+	Throughput    []float64 // 每个时间片的吞吐量（B/s），多连接测速时为各连接之和
 	Colo          string
+	TCPInfo       model.TCPInfo // 测速连接的内核统计，仅 Linux 上的 TCP 测试有数据
 }
 
 // TestDownloadSpeed 对单个 IP 进行下载速度测试
-func TestDownloadSpeed(ip *net.IPAddr, testURL string, timeout time.Duration, rateLimitMB float64) (*SpeedTestResult, error) {
+func (s *Session) TestDownloadSpeed(ip *net.IPAddr, port int, testURL string, timeout time.Duration, rateLimitMB float64) (*SpeedTestResult, error) {
+	return s.TestDownloadSpeedStreams(ip, port, testURL, FixedDuration(timeout), rateLimitMB, 1)
+}
+
+// TestDownloadSpeedStreams 通过 streams 个并发连接对单个 IP 进行下载速度测试，rateLimitMB 为所有连接的总限速，
+// 每个连接按 duration 独立决定何时停止
+func (s *Session) TestDownloadSpeedStreams(ip *net.IPAddr, port int, testURL string, duration DurationPolicy, rateLimitMB float64, streams int) (*SpeedTestResult, error) {
 	// 默认使用与 CloudflareST.exe 相同的测速地址
 	finalURL := "https://cf.xiu2.xyz/url"
 	if testURL != "" {
 		finalURL = testURL // 允许外部传入覆盖
 	}
 
-	speed, colo, err := downloadHandler(ip, finalURL, timeout, rateLimitMB)
+	recorder := newTCPInfoRecorder()
+	client := newSpeedTestClient(newTransport(recorder.dialContext(ip, port, 2*time.Second, s.meter()), s.fingerprint()), duration.MaxDuration)
+	res, err := parallelDownload(client, finalURL, duration, rateLimitMB, streams)
+	client.CloseIdleConnections()
 	if err != nil {
 		return nil, err
 	}
-	return &SpeedTestResult{DownloadSpeed: speed, Colo: colo}, nil
+	res.TCPInfo = recorder.snapshot()
+	return res, nil
+}
+
+// parallelDownload 并发执行 streams 个下载，只要有一个成功即返回结果，全部失败时返回第一个错误。
+// HTTP/1.1 下每个并发请求使用独立的连接，HTTP/2（模拟浏览器 TLS 指纹时）与 HTTP/3 下它们复用同一个连接的多个流。
+func parallelDownload(client *http.Client, testURL string, duration DurationPolicy, rateLimitMB float64, streams int) (*SpeedTestResult, error) {
+	if streams < 1 {
+		streams = 1
+	}
+	var (
+		wg     sync.WaitGroup
+		speeds = make([]float64, streams)
+		colos  = make([]string, streams)
+		curves = make([][]float64, streams)
+		errs   = make([]error, streams)
+	)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			speeds[i], colos[i], curves[i], errs[i] = downloadHandler(client, testURL, duration, rateLimitMB/float64(streams))
+		}(i)
+	}
+	wg.Wait()
+
+	res := &SpeedTestResult{StreamSpeeds: speeds}
+	var firstErr error
+	for i, err := range errs {
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		res.DownloadSpeed += speeds[i]
+		res.Throughput = addCurves(res.Throughput, curves[i])
+		if res.Colo == "" {
+			res.Colo = colos[i]
+		}
+	}
+	if firstErr != nil && res.DownloadSpeed == 0 {
+		return nil, firstErr
+	}
+	return res, nil
 }
 
-// downloadHandler 是实际执行下载测速的内部函数
-func downloadHandler(ip *net.IPAddr, testURL string, timeout time.Duration, rateLimitMB float64) (float64, string, error) {
-	client := &http.Client{
-		Transport: &http.Transport{DialContext: getDialContext(ip, DefaultTCPPort)},
+// addCurves 将两条吞吐量曲线逐点相加，长度取较长者
+func addCurves(a, b []float64) []float64 {
+	if len(b) > len(a) {
+		a, b = b, a
+	}
+	sum := append([]float64(nil), a...)
+	for i, v := range b {
+		sum[i] += v
+	}
+	return sum
+}
+
+// CheckSpeedURL 通过系统 DNS 解析正常访问测速地址，只读取少量数据，用于确认地址本身可用（返回 2xx）
+func (s *Session) CheckSpeedURL(testURL string, timeout time.Duration) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: timeout}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return s.meter().WrapConn(conn), nil
+	}
+	client := newSpeedTestClient(transport, timeout)
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequest("GET", testURL, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Range", "bytes=0-1023")
+	response, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%w: %d", ErrInvalidStatus, response.StatusCode)
+	}
+	_, err = io.CopyN(io.Discard, response.Body, 1024)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+	return nil
+}
+
+// newSpeedTestClient 使用给定的传输层创建测速用的 HTTP 客户端
+func newSpeedTestClient(transport http.RoundTripper, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: transport,
 		Timeout:   timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// 所有连接都指向同一端口，明文端口无法跟随到 HTTPS 的重定向
+			if via[0].URL.Scheme == "http" && req.URL.Scheme == "https" {
+				return fmt.Errorf("%w: %s", ErrHTTPSRedirect, req.URL)
+			}
 			if len(via) > 10 { // 限制最多重定向 10 次
 				return http.ErrUseLastResponse
 			}
 			return nil
 		},
 	}
+}
+
+// downloadHandler 是实际执行下载测速的内部函数，同时返回每个时间片的吞吐量曲线（B/s）
+func downloadHandler(client *http.Client, testURL string, duration DurationPolicy, rateLimitMB float64) (float64, string, []float64, error) {
+	timeout := duration.MaxDuration
+
 	req, err := http.NewRequest("GET", testURL, nil)
 	if err != nil {
-		return 0.0, "", fmt.Errorf("创建请求失败: %w", err)
+		return 0.0, "", nil, fmt.Errorf("创建请求失败: %w", err)
 	}
 
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_12_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.80 Safari/537.36")
 
 	response, err := client.Do(req)
 	if err != nil {
-		return 0.0, "", fmt.Errorf("请求失败: %w", err)
+		return 0.0, "", nil, fmt.Errorf("请求失败: %w", err)
 	}
 	defer response.Body.Close()
 	if response.StatusCode != 200 {
 		bodyBytes, err := io.ReadAll(response.Body)
-		errorMsg := fmt.Sprintf("无效的状态码: %d", response.StatusCode)
+		errorMsg := fmt.Sprintf("%d", response.StatusCode)
 		if err == nil && len(bodyBytes) > 0 {
 			// 将响应体内容附加到错误信息中，限制长度以防刷屏
 			bodyStr := string(bodyBytes)
@@ -73,7 +193,7 @@ func downloadHandler(ip *net.IPAddr, testURL string, timeout time.Duration, rate
 			}
 			errorMsg = fmt.Sprintf("%s, 响应: %s", errorMsg, bodyStr)
 		}
-		return 0.0, "", fmt.Errorf(errorMsg)
+		return 0.0, "", nil, fmt.Errorf("%w: %s", ErrInvalidStatus, errorMsg)
 	}
 	// 通过头部 Server 值判断是 Cloudflare 还是 AWS CloudFront 并设置 cfRay 为各自的机场地区码完整内容
 	colo := getHeaderColo(response.Header)
@@ -96,13 +216,16 @@ func downloadHandler(ip *net.IPAddr, testURL string, timeout time.Duration, rate
 
 	var (
 		contentRead     int64 = 0
-		timeSlice             = timeout / 100
+		timeSlice             = timeout / ThroughputSlices
 		timeCounter           = 1
 		lastContentRead int64 = 0
+		sliceBytes            = make([]int64, ThroughputSlices) // 每个时间片内读取的字节数
+		lastSlice             = 0
 	)
 
 	var nextTime = timeStart.Add(timeSlice * time.Duration(timeCounter))
 	e := ewma.NewMovingAverage()
+	tracker := duration.newTracker()
 
 	// 创建带超时的上下文
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
@@ -116,6 +239,10 @@ func downloadHandler(ip *net.IPAddr, testURL string, timeout time.Duration, rate
 			nextTime = timeStart.Add(timeSlice * time.Duration(timeCounter))
 			e.Add(float64(contentRead - lastContentRead))
 			lastContentRead = contentRead
+			// 速度估计已经收敛，提前结束测速
+			if tracker.add(e.Value(), currentTime.Sub(timeStart)) {
+				break
+			}
 		}
 		// 如果超出下载测速时间，则退出循环（终止测速）
 		if currentTime.After(timeEnd) {
@@ -145,8 +272,16 @@ func downloadHandler(ip *net.IPAddr, testURL string, timeout time.Duration, rate
 			e.Add(float64(contentRead-lastContentRead) / (float64(currentTime.Sub(last_time_slice)) / float64(timeSlice)))
 		}
 		contentRead += int64(bufferRead)
+		// 按读取完成的时刻归入时间片，长时间阻塞的读取会在曲线上留下为 0 的时间片
+		lastSlice = min(int(time.Since(timeStart)/timeSlice), ThroughputSlices-1)
+		sliceBytes[lastSlice] += int64(bufferRead)
 	}
 	// B/s
 	speed := e.Value() / (timeout.Seconds() / 120)
-	return speed, colo, nil
+
+	curve := make([]float64, lastSlice+1)
+	for i := range curve {
+		curve[i] = float64(sliceBytes[i]) / timeSlice.Seconds()
+	}
+	return speed, colo, curve, nil
 }
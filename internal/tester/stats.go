@@ -0,0 +1,92 @@
+package tester
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"sort"
+	"time"
+)
+
+// LatencySample 记录一次 HTTPing 请求各阶段的耗时，复用连接时 Connect 与 TLS 为 0
+type LatencySample struct {
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration
+	Total   time.Duration
+}
+
+// summarizeSamples 计算延迟样本的分布与各阶段平均耗时。
+// setup 是获取 Colo 的首个请求的样本，只用于统计连接与握手耗时。
+func summarizeSamples(samples []LatencySample, setup LatencySample) model.LatencyStats {
+	var stats model.LatencyStats
+	if len(samples) == 0 {
+		return stats
+	}
+
+	totals := make([]time.Duration, len(samples))
+	var (
+		ttfbSum                time.Duration
+		connectSum, tlsSum     time.Duration
+		connectCount, tlsCount int
+		jitterSum              time.Duration
+	)
+	for i, s := range append([]LatencySample{setup}, samples...) {
+		if s.Connect > 0 {
+			connectSum += s.Connect
+			connectCount++
+		}
+		if s.TLS > 0 {
+			tlsSum += s.TLS
+			tlsCount++
+		}
+		if i == 0 {
+			continue
+		}
+		totals[i-1] = s.Total
+		ttfbSum += s.TTFB
+		if i > 1 {
+			jitterSum += absDuration(s.Total - samples[i-2].Total)
+		}
+	}
+
+	sorted := append([]time.Duration(nil), totals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	stats.MinDelay = sorted[0]
+	stats.MedianDelay = percentile(sorted, 0.5)
+	stats.P90Delay = percentile(sorted, 0.9)
+	stats.MaxDelay = sorted[len(sorted)-1]
+	stats.Samples = len(samples)
+	stats.DelayCILow, stats.DelayCIHigh = confidenceInterval(totals)
+	if len(samples) > 1 {
+		stats.Jitter = jitterSum / time.Duration(len(samples)-1)
+	}
+	stats.TTFB = ttfbSum / time.Duration(len(samples))
+	if connectCount > 0 {
+		stats.ConnectTime = connectSum / time.Duration(connectCount)
+	}
+	if tlsCount > 0 {
+		stats.TLSTime = tlsSum / time.Duration(tlsCount)
+	}
+	return stats
+}
+
+// percentile 使用线性插值计算已排序样本的分位数
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := rank - float64(lower)
+	return sorted[lower] + time.Duration(frac*float64(sorted[lower+1]-sorted[lower]))
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
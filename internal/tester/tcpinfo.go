@@ -0,0 +1,88 @@
+package tester
+
+import (
+	"Domain_IP_Selector_Go/internal/usage"
+	"Domain_IP_Selector_Go/pkg/model"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpInfoRecorder 记录经由它拨号的所有 TCP 连接的内核统计（TCP_INFO），仅在 Linux 上有数据。
+// 连接关闭前读取一次，snapshot 时对仍未关闭的连接再读取一次。
+type tcpInfoRecorder struct {
+	mu     sync.Mutex
+	open   map[*tcpInfoConn]struct{}
+	closed []model.TCPInfo
+}
+
+func newTCPInfoRecorder() *tcpInfoRecorder {
+	return &tcpInfoRecorder{open: make(map[*tcpInfoConn]struct{})}
+}
+
+// dialContext 与 getDialContextTimeout 相同，但会登记拨出的连接
+func (r *tcpInfoRecorder) dialContext(ip *net.IPAddr, port int, timeout time.Duration, meter *usage.Meter) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := getDialContextTimeout(ip, port, timeout, meter)
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		c := &tcpInfoConn{Conn: conn, recorder: r}
+		r.mu.Lock()
+		r.open[c] = struct{}{}
+		r.mu.Unlock()
+		return c, nil
+	}
+}
+
+// snapshot 汇总所有连接的统计：计数类指标累加，RTT 与交付速率取收到数据段最多的连接
+func (r *tcpInfoRecorder) snapshot() model.TCPInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := append([]model.TCPInfo(nil), r.closed...)
+	for c := range r.open {
+		if info, ok := readTCPInfo(c.Conn); ok {
+			infos = append(infos, info)
+		}
+	}
+
+	var (
+		total   model.TCPInfo
+		busiest model.TCPInfo
+	)
+	for i, info := range infos {
+		total.Retransmits += info.Retransmits
+		total.Lost += info.Lost
+		total.OutOfOrder += info.OutOfOrder
+		total.SegmentsIn += info.SegmentsIn
+		total.SegmentsOut += info.SegmentsOut
+		if i == 0 || info.SegmentsIn > busiest.SegmentsIn {
+			busiest = info
+		}
+	}
+	total.RTT, total.RTTVar, total.DeliveryRate = busiest.RTT, busiest.RTTVar, busiest.DeliveryRate
+	return total
+}
+
+// tcpInfoConn 在关闭前读取连接的 TCP_INFO
+type tcpInfoConn struct {
+	net.Conn
+	recorder *tcpInfoRecorder
+	once     sync.Once
+}
+
+func (c *tcpInfoConn) Close() error {
+	c.once.Do(func() {
+		r := c.recorder
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if info, ok := readTCPInfo(c.Conn); ok {
+			r.closed = append(r.closed, info)
+		}
+		delete(r.open, c)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package tester
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTCPInfo 通过 getsockopt(TCP_INFO) 读取连接的内核统计
+func readTCPInfo(conn net.Conn) (model.TCPInfo, bool) {
+	// 流量统计等包装层通过 NetConn 暴露底层连接
+	for {
+		wrapped, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapped.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return model.TCPInfo{}, false
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return model.TCPInfo{}, false
+	}
+
+	var (
+		info    *unix.TCPInfo
+		sockErr error
+	)
+	if err := rawConn.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil || sockErr != nil {
+		return model.TCPInfo{}, false
+	}
+
+	return model.TCPInfo{
+		RTT:          time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:       time.Duration(info.Rttvar) * time.Microsecond,
+		Retransmits:  info.Total_retrans,
+		Lost:         info.Lost,
+		OutOfOrder:   info.Rcv_ooopack,
+		SegmentsIn:   info.Segs_in,
+		SegmentsOut:  info.Segs_out,
+		DeliveryRate: info.Delivery_rate,
+	}, true
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package tester
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"net"
+)
+
+// readTCPInfo 在非 Linux 平台上不可用
+func readTCPInfo(conn net.Conn) (model.TCPInfo, bool) {
+	return model.TCPInfo{}, false
+}
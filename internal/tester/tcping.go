@@ -0,0 +1,93 @@
+package tester
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TestTCPLatency 通过 TCP 握手（与 CloudflareST 默认模式相同）测试单个 IP 的延迟。
+// 结果中不包含 Colo，需要时可对通过筛选的 IP 调用 DetectColo。
+func (s *Session) TestTCPLatency(ip *net.IPAddr, port int, pingTimes int, timeout time.Duration) (*HttpingResult, error) {
+	recorder := newTCPInfoRecorder()
+	dial := recorder.dialContext(ip, port, timeout, s.meter())
+
+	success := 0
+	var (
+		totalDelay time.Duration
+		samples    []LatencySample
+	)
+	for i := 0; i < pingTimes; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		startTime := time.Now()
+		conn, err := dial(ctx, "tcp", "")
+		cancel()
+		if err != nil {
+			continue
+		}
+		duration := time.Since(startTime)
+		_ = conn.Close()
+
+		success++
+		totalDelay += duration
+		samples = append(samples, LatencySample{Connect: duration, Total: duration})
+	}
+
+	if success == 0 {
+		return nil, fmt.Errorf("all tcpings failed")
+	}
+
+	return &HttpingResult{
+		Delay:    totalDelay / time.Duration(success),
+		LossRate: float64(pingTimes-success) / float64(pingTimes),
+		Stats:    summarizeSamples(samples, LatencySample{}),
+		TCPInfo:  recorder.snapshot(),
+	}, nil
+}
+
+// DetectColo 通过单次 HTTP 请求获取 IP 所在的数据中心（Colo）及 trace 信息
+func (s *Session) DetectColo(ip *net.IPAddr, port int, testURL string, probe Probe) (string, model.TraceInfo, error) {
+	request, err := http.NewRequest(http.MethodGet, testURL, nil)
+	if err != nil {
+		return "", model.TraceInfo{}, err
+	}
+	request.Header.Set("User-Agent", probe.UserAgent)
+	client := newLatencyClient(getDialContextTimeout(ip, port, probe.DialTimeout, s.meter()), probe.ClientTimeout, s.fingerprint())
+	defer client.CloseIdleConnections()
+	response, err := client.Do(request)
+	if err != nil {
+		return "", model.TraceInfo{}, err
+	}
+	defer response.Body.Close()
+
+	colo, trace := responseTrace(response)
+	if colo == "" {
+		return "", trace, fmt.Errorf("响应中未找到 Colo 信息")
+	}
+	return colo, trace, nil
+}
+
+// TestHandshake 进行一次 TCP 握手（withTLS 为 true 时再完成一次 TLS 握手），返回总耗时。
+// 用于在完整的延迟测试前快速淘汰不可达或明显较慢的 IP。
+func (s *Session) TestHandshake(ip *net.IPAddr, port int, serverName string, withTLS bool, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	conn, err := getDialContext(ip, port, s.meter())(ctx, "tcp", "")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if !withTLS {
+		return time.Since(startTime), nil
+	}
+
+	if err := clientHandshake(ctx, conn, serverName, s.fingerprint()); err != nil {
+		return 0, err
+	}
+	return time.Since(startTime), nil
+}
@@ -0,0 +1,51 @@
+package tester
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxTraceBodySize /cdn-cgi/trace 的响应体通常只有几百字节，超出部分不予解析
+const maxTraceBodySize = 4096
+
+// parseTrace 解析 /cdn-cgi/trace 的 "key=value" 响应体，返回数据中心代码与连接信息。
+// 响应体中没有 colo 字段时认为不是 trace 格式，ok 为 false。
+func parseTrace(body io.Reader) (colo string, trace model.TraceInfo, ok bool) {
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "colo":
+			colo = value
+		case "ip":
+			trace.ClientIP = value
+		case "loc":
+			trace.Country = value
+		case "http":
+			trace.HTTPVersion = value
+		case "tls":
+			trace.TLSVersion = value
+		case "sni":
+			trace.SNI = value
+		case "warp":
+			trace.Warp = value
+		}
+	}
+	return colo, trace, colo != ""
+}
+
+// responseTrace 读取并解析响应体中的 trace 信息，响应体缺失或不是 trace 格式时从响应头获取 Colo
+func responseTrace(response *http.Response) (string, model.TraceInfo) {
+	colo, trace, ok := parseTrace(io.LimitReader(response.Body, maxTraceBodySize))
+	io.Copy(io.Discard, response.Body)
+	if !ok {
+		colo = getHeaderColo(response.Header)
+	}
+	return colo, trace
+}
@@ -0,0 +1,121 @@
+package tester
+
+import (
+	"Domain_IP_Selector_Go/pkg/model"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// UploadSize 单次上传测速最多发送的字节数，在测速时间内发送完毕时提前结束
+const UploadSize = 50 * 1000 * 1000
+
+// UploadTestResult 包含一次上传速度测试的结果
+type UploadTestResult struct {
+	UploadSpeed float64 // in B/s
+	Colo        string
+	TCPInfo     model.TCPInfo // 上传连接的内核统计，上传方向的重传与丢包在此可见，仅 Linux 有数据
+}
+
+// TestUploadSpeed 通过 POST 向 testURL（如 https://speed.cloudflare.com/__up）上传数据，测试单个 IP 的上传速度
+func (s *Session) TestUploadSpeed(ip *net.IPAddr, port int, testURL string, timeout time.Duration, rateLimitMB float64) (*UploadTestResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	body := &uploadBody{ctx: ctx, remaining: UploadSize}
+	if rateLimitMB > 0 {
+		// 上传速度直接由发送字节数计算，较小的桶可以避免初始突发抬高结果
+		limit := rateLimitMB * 1024 * 1024
+		body.limiter = rate.NewLimiter(rate.Limit(limit), max(int(limit/10), BufferSize))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, testURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.ContentLength = UploadSize
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("User-Agent", DefaultProbe.UserAgent)
+
+	recorder := newTCPInfoRecorder()
+	// 超时由 ctx 控制，以便区分“测速时间用完”与其他错误
+	client := newSpeedTestClient(newTransport(recorder.dialContext(ip, port, 2*time.Second, s.meter()), s.fingerprint()), 0)
+	defer client.CloseIdleConnections()
+
+	response, err := client.Do(req)
+	// 只计算发送请求体的时间，不含建立连接、TLS 握手与等待服务器响应
+	sent, elapsed := body.sent.Load(), body.elapsed(time.Now())
+	if err != nil {
+		// 测速时间用完时上传尚未结束属于正常情况
+		if !errors.Is(err, context.DeadlineExceeded) || sent == 0 || elapsed <= 0 {
+			return nil, fmt.Errorf("请求失败: %w", err)
+		}
+		return &UploadTestResult{UploadSpeed: float64(sent) / elapsed.Seconds(), TCPInfo: recorder.snapshot()}, nil
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidStatus, response.StatusCode)
+	}
+	if elapsed <= 0 {
+		return nil, fmt.Errorf("服务器未读取上传数据")
+	}
+	return &UploadTestResult{
+		UploadSpeed: float64(sent) / elapsed.Seconds(),
+		Colo:        getHeaderColo(response.Header),
+		TCPInfo:     recorder.snapshot(),
+	}, nil
+}
+
+// uploadBody 生成上传数据，按限速器控制发送速率，统计已交给传输层的字节数，
+// 并记录第一次被读取与数据全部读完的时间
+type uploadBody struct {
+	ctx       context.Context
+	limiter   *rate.Limiter
+	remaining int64
+	sent      atomic.Int64
+	started   atomic.Int64 // 第一次 Read 的时间（UnixNano），0 表示尚未读取
+	drained   atomic.Int64 // 数据全部读完的时间（UnixNano），0 表示尚未读完
+}
+
+// elapsed 返回从第一次读取到数据读完所用的时间，尚未读完时计算到 now，从未读取时返回 0
+func (b *uploadBody) elapsed(now time.Time) time.Duration {
+	started := b.started.Load()
+	if started == 0 {
+		return 0
+	}
+	end := b.drained.Load()
+	if end == 0 {
+		end = now.UnixNano()
+	}
+	return time.Duration(end - started)
+}
+
+func (b *uploadBody) Read(p []byte) (int, error) {
+	b.started.CompareAndSwap(0, time.Now().UnixNano())
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), b.remaining))
+	if b.limiter != nil {
+		n = min(n, b.limiter.Burst())
+		if err := b.limiter.WaitN(b.ctx, n); err != nil {
+			// 剩余的测速时间不足以等到下一批令牌，与测速时间用完同样处理
+			return 0, context.DeadlineExceeded
+		}
+	}
+	clear(p[:n])
+	b.remaining -= int64(n)
+	b.sent.Add(int64(n))
+	if b.remaining == 0 {
+		// 传输层按 ContentLength 读取，不一定会再读到 EOF
+		b.drained.CompareAndSwap(0, time.Now().UnixNano())
+	}
+	return n, nil
+}
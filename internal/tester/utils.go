@@ -1,6 +1,7 @@
 package tester
 
 import (
+	"Domain_IP_Selector_Go/internal/usage"
 	"context"
 	"fmt"
 	"net"
@@ -13,9 +14,14 @@ import (
 const (
 	// DefaultTCPPort 默认测速端口
 	DefaultTCPPort = 443
+	// DefaultHTTPPort 明文 HTTP 模式的默认测速端口
+	DefaultHTTPPort = 80
 )
 
 var (
+	// PlainHTTPPorts Cloudflare 以明文 HTTP 代理的端口，其余端口（443、2053、2083、2087、2096、8443）使用 TLS
+	PlainHTTPPorts = map[int]bool{80: true, 8080: true, 8880: true, 2052: true, 2082: true, 2086: true, 2095: true}
+
 	// ColoRegexp 用于从 cf-ray 中提取数据中心代码
 	ColoRegexp = regexp.MustCompile(`[A-Z]{3}`)
 )
@@ -25,8 +31,32 @@ func isIPv4(ip string) bool {
 	return strings.Contains(ip, ".")
 }
 
-// getDialContext 创建一个自定义的拨号上下文，强制通过指定的 IP 地址进行连接
-func getDialContext(ip *net.IPAddr, port int) func(ctx context.Context, network, address string) (net.Conn, error) {
+// IsTLSPort 判断端口是否使用 TLS
+func IsTLSPort(port int) bool {
+	return !PlainHTTPPorts[port]
+}
+
+// URLForPort 根据端口是否使用 TLS 调整测试地址的协议（https 或 http）
+func URLForPort(rawURL string, port int) string {
+	if IsTLSPort(port) {
+		if strings.HasPrefix(rawURL, "http://") {
+			return "https://" + strings.TrimPrefix(rawURL, "http://")
+		}
+		return rawURL
+	}
+	if strings.HasPrefix(rawURL, "https://") {
+		return "http://" + strings.TrimPrefix(rawURL, "https://")
+	}
+	return rawURL
+}
+
+// getDialContext 创建一个自定义的拨号上下文，强制通过指定的 IP 地址进行连接，连接的流量计入 meter（可以为 nil）
+func getDialContext(ip *net.IPAddr, port int, meter *usage.Meter) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return getDialContextTimeout(ip, port, 2*time.Second, meter)
+}
+
+// getDialContextTimeout 与 getDialContext 相同，但使用指定的连接超时
+func getDialContextTimeout(ip *net.IPAddr, port int, timeout time.Duration, meter *usage.Meter) func(ctx context.Context, network, address string) (net.Conn, error) {
 	var fakeSourceAddr string
 	if isIPv4(ip.String()) {
 		fakeSourceAddr = fmt.Sprintf("%s:%d", ip.String(), port)
@@ -34,7 +64,11 @@ func getDialContext(ip *net.IPAddr, port int) func(ctx context.Context, network,
 		fakeSourceAddr = fmt.Sprintf("[%s]:%d", ip.String(), port)
 	}
 	return func(ctx context.Context, network, address string) (net.Conn, error) {
-		return (&net.Dialer{Timeout: 2 * time.Second}).DialContext(ctx, network, fakeSourceAddr)
+		conn, err := (&net.Dialer{Timeout: timeout}).DialContext(ctx, network, fakeSourceAddr)
+		if err != nil {
+			return nil, err
+		}
+		return meter.WrapConn(conn), nil
 	}
 }
 
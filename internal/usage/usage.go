@@ -0,0 +1,205 @@
+package usage
+
+import (
+	"net"
+	"sort"
+	"sync"
+)
+
+// 运行阶段
+const (
+	StageDNS     = "dns"
+	StageLatency = "latency"
+	StageSpeed   = "speed"
+)
+
+// Counts 是发送与接收的字节数
+type Counts struct {
+	Sent     int64 `json:"Sent"`
+	Received int64 `json:"Received"`
+}
+
+// Total 返回发送与接收的字节数之和
+func (c Counts) Total() int64 {
+	return c.Sent + c.Received
+}
+
+func (c *Counts) add(sent, received int64) {
+	c.Sent += sent
+	c.Received += received
+}
+
+// StageUsage 是单个阶段的流量
+type StageUsage struct {
+	Stage string `json:"Stage"`
+	Counts
+}
+
+// IPUsage 是与单个 IP 之间的流量
+type IPUsage struct {
+	IP string `json:"IP"`
+	Counts
+}
+
+// Report 是一次运行的流量汇总
+type Report struct {
+	Total  Counts       `json:"Total"`
+	Stages []StageUsage `json:"Stages"` // 按阶段开始的顺序排列
+	IPs    []IPUsage    `json:"IPs"`    // 按流量倒序排列
+}
+
+// Meter 统计一次运行中各阶段、各 IP 的流量。字节数在应用层按连接读写统计，不含 IP/TCP/UDP 头部。
+// nil Meter 的所有方法都不做任何事，所有方法都是并发安全的。
+type Meter struct {
+	mu     sync.Mutex
+	stage  string
+	order  []string
+	stages map[string]*Counts
+	ips    map[string]*Counts
+}
+
+// NewMeter 创建流量统计
+func NewMeter() *Meter {
+	return &Meter{stages: make(map[string]*Counts), ips: make(map[string]*Counts)}
+}
+
+// SetStage 设置之后的流量计入的阶段
+func (m *Meter) SetStage(stage string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stage = stage
+	if _, ok := m.stages[stage]; !ok {
+		m.stages[stage] = &Counts{}
+		m.order = append(m.order, stage)
+	}
+}
+
+// Add 将与 ip 之间的流量计入当前阶段
+func (m *Meter) Add(ip string, sent, received int64) {
+	if m == nil || (sent == 0 && received == 0) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stage != "" {
+		m.stages[m.stage].add(sent, received)
+	}
+	c, ok := m.ips[ip]
+	if !ok {
+		c = &Counts{}
+		m.ips[ip] = c
+	}
+	c.add(sent, received)
+}
+
+// IP 返回与 ip 之间的流量
+func (m *Meter) IP(ip string) Counts {
+	if m == nil {
+		return Counts{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.ips[ip]; ok {
+		return *c
+	}
+	return Counts{}
+}
+
+// Stage 返回单个阶段的流量
+func (m *Meter) Stage(stage string) Counts {
+	if m == nil {
+		return Counts{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.stages[stage]; ok {
+		return *c
+	}
+	return Counts{}
+}
+
+// Report 返回当前的流量汇总
+func (m *Meter) Report() Report {
+	report := Report{Stages: []StageUsage{}, IPs: []IPUsage{}}
+	if m == nil {
+		return report
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, stage := range m.order {
+		report.Stages = append(report.Stages, StageUsage{Stage: stage, Counts: *m.stages[stage]})
+	}
+	for ip, c := range m.ips {
+		report.IPs = append(report.IPs, IPUsage{IP: ip, Counts: *c})
+		report.Total.add(c.Sent, c.Received)
+	}
+	sort.Slice(report.IPs, func(i, j int) bool {
+		if report.IPs[i].Total() != report.IPs[j].Total() {
+			return report.IPs[i].Total() > report.IPs[j].Total()
+		}
+		return report.IPs[i].IP < report.IPs[j].IP
+	})
+	return report
+}
+
+// WrapConn 返回统计读写字节数的连接，流量按连接的远端 IP 计入。m 为 nil 时原样返回 conn。
+func (m *Meter) WrapConn(conn net.Conn) net.Conn {
+	if m == nil {
+		return conn
+	}
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	counting := &countingConn{Conn: conn, meter: m, ip: ip}
+	// net.Resolver 根据连接是否实现 net.PacketConn 决定按 UDP 还是 TCP 格式收发 DNS 报文
+	if pc, ok := conn.(net.PacketConn); ok {
+		return &countingPacketConn{countingConn: counting, packetConn: pc}
+	}
+	return counting
+}
+
+// countingConn 在每次读写后将字节数计入 Meter
+type countingConn struct {
+	net.Conn
+	meter *Meter
+	ip    string
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.meter.Add(c.ip, 0, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.meter.Add(c.ip, int64(n), 0)
+	return n, err
+}
+
+// NetConn 返回被包装的连接，供需要读取底层 socket 的代码使用
+func (c *countingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// countingPacketConn 是 UDP 连接的 countingConn，保留 net.PacketConn 接口
+type countingPacketConn struct {
+	*countingConn
+	packetConn net.PacketConn
+}
+
+func (c *countingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.packetConn.ReadFrom(b)
+	c.meter.Add(c.ip, 0, int64(n))
+	return n, addr, err
+}
+
+func (c *countingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.packetConn.WriteTo(b, addr)
+	c.meter.Add(c.ip, int64(n), 0)
+	return n, err
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"net"
+	"strconv"
 	"time"
 )
 
@@ -9,19 +10,82 @@ import (
 type IPInfo struct {
 	Address      net.IP
 	SourceDomain string // 从哪个域名解析出来的
+	Prefix       string // 宣告该 IP 的 BGP 前缀，例如 "104.16.0.0/20"，未加载路由表时为空
+	OriginASN    uint32 // 该前缀的源 ASN
+	Port         int    // 测试使用的端口，例如 443
+}
+
+// Endpoint 返回 "IP:端口" 形式的地址，未设置端口时只返回 IP
+func (i IPInfo) Endpoint() string {
+	if i.Port == 0 {
+		return i.Address.String()
+	}
+	return net.JoinHostPort(i.Address.String(), strconv.Itoa(i.Port))
+}
+
+// LatencyStats 包含延迟样本的分布统计与各阶段耗时
+type LatencyStats struct {
+	MinDelay    time.Duration
+	MedianDelay time.Duration
+	P90Delay    time.Duration
+	MaxDelay    time.Duration
+	Jitter      time.Duration // 相邻样本总耗时之差的平均绝对值
+	ConnectTime time.Duration // TCP 连接建立耗时（新建连接的平均值）
+	TLSTime     time.Duration // TLS 握手耗时（新建连接的平均值）
+	TTFB        time.Duration // 从发出请求到收到首字节的平均耗时
+	DelayCILow  time.Duration // 平均延迟 95% 置信区间的下限
+	DelayCIHigh time.Duration // 平均延迟 95% 置信区间的上限
+	Samples     int           // 成功的延迟样本数
+}
+
+// TCPInfo 是从 Linux 内核 TCP_INFO 读取的连接统计，其他平台上为零值
+type TCPInfo struct {
+	RTT          time.Duration // 内核平滑 RTT
+	RTTVar       time.Duration // RTT 方差
+	Retransmits  uint32        // 累计重传的数据段
+	Lost         uint32        // 被判定丢失的数据段
+	OutOfOrder   uint32        // 收到的乱序数据段，下载时反映服务端方向的丢包
+	SegmentsIn   uint32
+	SegmentsOut  uint32
+	DeliveryRate uint64 // 最近的交付速率，B/s
+}
+
+// LossRatio 返回重传、丢失与乱序数据段占收发数据段总数的比例
+func (t TCPInfo) LossRatio() float64 {
+	segments := t.SegmentsIn + t.SegmentsOut
+	if segments == 0 {
+		return 0
+	}
+	return float64(t.Retransmits+t.Lost+t.OutOfOrder) / float64(segments)
+}
+
+// TraceInfo 是从 /cdn-cgi/trace 响应体中解析出的连接信息
+type TraceInfo struct {
+	ClientIP    string // Cloudflare 观察到的客户端公网 IP
+	Country     string // 客户端所在国家/地区代码（loc），例如 "CN"
+	HTTPVersion string // 协商的 HTTP 版本，例如 "http/2"
+	TLSVersion  string // 协商的 TLS 版本，例如 "TLSv1.3"，明文 HTTP 时为 "off"
+	SNI         string // SNI 状态: "plaintext"、"encrypted" 或 "off"
+	Warp        string // WARP 状态: "off"、"on" 或 "plus"
 }
 
 // LatencyResult 包含 HTTPing 延迟测试后的结果
 type LatencyResult struct {
 	IPInfo
+	LatencyStats
 	Delay    time.Duration
 	LossRate float64
 	Colo     string // e.g., "SJC"
 	Region   string // e.g., "North America"
+	Trace    TraceInfo
+	TCPInfo  TCPInfo // 延迟测试连接的内核统计
+
+	QUICDelay    time.Duration // HTTP/3 延迟，未启用或失败时为 0
+	QUICLossRate float64
 }
 
 // FinalResult 包含所有信息的最终结果
 type FinalResult struct {
 	LatencyResult
 	DownloadSpeed float64 // in MB/s
-}
\ No newline at end of file
+}